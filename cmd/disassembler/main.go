@@ -3,9 +3,9 @@ package main
 import (
 	"flag"
 	"fmt"
-	"io/ioutil"
 
 	in "github.com/tbtommyb/goboy/pkg/instructions"
+	"github.com/tbtommyb/goboy/pkg/romfile"
 )
 
 func printOp(i in.Instruction) {
@@ -15,7 +15,7 @@ func printOp(i in.Instruction) {
 func main() {
 	romPtr := flag.String("path", "input.rom", "ROM path to read from")
 	flag.Parse()
-	data, err := ioutil.ReadFile(*romPtr)
+	data, err := romfile.Load(*romPtr)
 	if err != nil {
 		fmt.Printf("File reading error %#v", err)
 		return
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"time"
+
+	"github.com/tbtommyb/goboy/pkg/cpu"
+	"github.com/tbtommyb/goboy/pkg/display"
+	"github.com/tbtommyb/goboy/pkg/romfile"
+)
+
+// Frames per second of the real hardware
+const realFPS = float64(cpu.ClockSpeed) / float64(cpu.ClocksPerFrame)
+
+type result struct {
+	ROM            string  `json:"rom"`
+	Frames         int     `json:"frames"`
+	Seconds        float64 `json:"seconds"`
+	FPS            float64 `json:"fps"`
+	RealTime       float64 `json:"real_time_percent"`
+	AllocsPerFrame float64 `json:"allocs_per_frame"`
+	BytesPerFrame  float64 `json:"bytes_per_frame"`
+}
+
+func run(rom, bios []byte, frames int) (result, error) {
+	gameboy := cpu.Init(bios != nil)
+	if err := gameboy.LoadROM(rom); err != nil {
+		return result{}, err
+	}
+	if bios != nil {
+		gameboy.LoadBIOS(bios)
+	}
+	gameboy.AttachDisplay(display.Init())
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+
+	// Step doesn't count the clocks spent dispatching interrupts or waking
+	// from HALT, so measure the clock itself
+	end := gameboy.Clocks() + uint64(frames)*cpu.ClocksPerFrame
+	for gameboy.Clocks() < end {
+		gameboy.HandleInterrupts()
+		gameboy.Step()
+	}
+
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	fps := float64(frames) / elapsed.Seconds()
+	return result{
+		Frames:         frames,
+		Seconds:        elapsed.Seconds(),
+		FPS:            fps,
+		RealTime:       100 * fps / realFPS,
+		AllocsPerFrame: float64(after.Mallocs-before.Mallocs) / float64(frames),
+		BytesPerFrame:  float64(after.TotalAlloc-before.TotalAlloc) / float64(frames),
+	}, nil
+}
+
+func printResult(r result) {
+	fmt.Printf("rom:              %s\n", r.ROM)
+	fmt.Printf("frames:           %d in %.2fs\n", r.Frames, r.Seconds)
+	fmt.Printf("emulated FPS:     %.1f\n", r.FPS)
+	fmt.Printf("real time:        %.0f%%\n", r.RealTime)
+	fmt.Printf("allocs per frame: %.2f (%.0f bytes)\n", r.AllocsPerFrame, r.BytesPerFrame)
+}
+
+func readResult(path string) result {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Error reading results %s", err.Error())
+	}
+	var r result
+	if err := json.Unmarshal(data, &r); err != nil {
+		log.Fatalf("Error parsing results %s: %s", path, err.Error())
+	}
+	return r
+}
+
+func change(before, after float64) string {
+	if before == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%+.1f%%", 100*(after-before)/before)
+}
+
+func compare(beforePath, afterPath string) {
+	before, after := readResult(beforePath), readResult(afterPath)
+	if before.ROM != after.ROM || before.Frames != after.Frames {
+		fmt.Printf("warning: comparing %s (%d frames) with %s (%d frames)\n\n",
+			before.ROM, before.Frames, after.ROM, after.Frames)
+	}
+	rows := []struct {
+		name          string
+		before, after float64
+		format        string
+	}{
+		{"emulated FPS", before.FPS, after.FPS, "%.1f"},
+		{"real time %", before.RealTime, after.RealTime, "%.0f"},
+		{"allocs/frame", before.AllocsPerFrame, after.AllocsPerFrame, "%.2f"},
+		{"bytes/frame", before.BytesPerFrame, after.BytesPerFrame, "%.0f"},
+	}
+	fmt.Printf("%-14s %14s %14s %10s\n", "", beforePath, afterPath, "change")
+	for _, row := range rows {
+		fmt.Printf("%-14s %14s %14s %10s\n", row.name,
+			fmt.Sprintf(row.format, row.before), fmt.Sprintf(row.format, row.after),
+			change(row.before, row.after))
+	}
+}
+
+func main() {
+	frames := flag.Int("frames", 3600, "number of frames to emulate")
+	biosPath := flag.String("bios", "", "BIOS path to read from")
+	patchPath := flag.String("patch", "", "IPS, UPS or BPS patch to apply, instead of one named after the ROM")
+	profilePath := flag.String("cpuprofile", "", "file to write a CPU profile to")
+	jsonPath := flag.String("json", "", "file to write results to as JSON")
+	compareMode := flag.Bool("compare", false, "compare two JSON results files instead of running a ROM")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] ROM\n       %s -compare BEFORE.json AFTER.json\n", os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *compareMode {
+		if flag.NArg() != 2 {
+			log.Fatalf("-compare needs two JSON results files")
+		}
+		compare(flag.Arg(0), flag.Arg(1))
+		return
+	}
+
+	if flag.NArg() == 0 {
+		log.Fatalf("ROM path not provided")
+	}
+
+	var bios []byte
+	if *biosPath != "" {
+		var err error
+		bios, err = ioutil.ReadFile(*biosPath)
+		if err != nil {
+			log.Fatalf("Error reading BIOS ROM %s", err.Error())
+		}
+	}
+	rom, err := romfile.LoadPatched(flag.Arg(0), *patchPath)
+	if err != nil {
+		log.Fatalf("Error reading ROM %s", err.Error())
+	}
+
+	if *profilePath != "" {
+		f, err := os.Create(*profilePath)
+		if err != nil {
+			log.Fatalf("Error creating CPU profile %s", err.Error())
+		}
+		defer f.Close()
+		if err := pprof.StartCPUProfile(f); err != nil {
+			log.Fatalf("Error starting CPU profile %s", err.Error())
+		}
+	}
+	r, err := run(rom, bios, *frames)
+	if *profilePath != "" {
+		pprof.StopCPUProfile()
+	}
+	if err != nil {
+		log.Fatalf("Error loading ROM %s", err.Error())
+	}
+	r.ROM = flag.Arg(0)
+
+	printResult(r)
+	if *profilePath != "" {
+		fmt.Printf("CPU profile:      %s\n", *profilePath)
+	}
+
+	if *jsonPath != "" {
+		data, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			log.Fatalf("Error encoding results %s", err.Error())
+		}
+		if err := ioutil.WriteFile(*jsonPath, append(data, '\n'), 0644); err != nil {
+			log.Fatalf("Error writing results %s", err.Error())
+		}
+	}
+}
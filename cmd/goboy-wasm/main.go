@@ -2,28 +2,27 @@ package main
 
 import (
 	"fmt"
+	"image"
 
 	"syscall/js"
 
 	"github.com/hajimehoshi/ebiten"
-	"github.com/hajimehoshi/ebiten/inpututil"
+	"github.com/hajimehoshi/ebiten/ebitenutil"
+	"github.com/tbtommyb/goboy"
 	"github.com/tbtommyb/goboy/pkg/constants"
 	"github.com/tbtommyb/goboy/pkg/cpu"
-	"github.com/tbtommyb/goboy/pkg/display"
+	"github.com/tbtommyb/goboy/pkg/romfile"
 )
 
-var EbitenFPS = 60
-var CyclesPerFrame = cpu.GameboyClockSpeed / EbitenFPS
-
-var keyMap = map[ebiten.Key]cpu.Button{
-	ebiten.KeyZ:         cpu.ButtonA,
-	ebiten.KeyX:         cpu.ButtonB,
-	ebiten.KeyBackspace: cpu.ButtonSelect,
-	ebiten.KeyEnter:     cpu.ButtonStart,
-	ebiten.KeyRight:     cpu.ButtonRight,
-	ebiten.KeyLeft:      cpu.ButtonLeft,
-	ebiten.KeyUp:        cpu.ButtonUp,
-	ebiten.KeyDown:      cpu.ButtonDown,
+var keyMap = map[ebiten.Key]goboy.Buttons{
+	ebiten.KeyZ:         goboy.ButtonA,
+	ebiten.KeyX:         goboy.ButtonB,
+	ebiten.KeyBackspace: goboy.ButtonSelect,
+	ebiten.KeyEnter:     goboy.ButtonStart,
+	ebiten.KeyRight:     goboy.ButtonRight,
+	ebiten.KeyLeft:      goboy.ButtonLeft,
+	ebiten.KeyUp:        goboy.ButtonUp,
+	ebiten.KeyDown:      goboy.ButtonDown,
 }
 
 type jsRom struct {
@@ -31,29 +30,43 @@ type jsRom struct {
 	data []byte
 }
 
+// showCrash draws the last frame with a note that emulation has stopped, so
+// the page keeps showing the game after a crash
+func showCrash(screen *ebiten.Image, emulator *goboy.Emulator, report *cpu.CrashReport) error {
+	screen.ReplacePixels(emulator.Framebuffer().(*image.RGBA).Pix)
+	return ebitenutil.DebugPrint(screen, fmt.Sprintf("Emulation stopped\nPC=%04X\nsee the console", report.PC))
+}
+
 func runGame(rom []byte) {
-	var err error
-	gameboy := cpu.Init(false)
-	gameboy.LoadROM(rom)
-	display := display.Init()
-	gameboy.AttachDisplay(display)
+	emulator, err := goboy.New(goboy.Options{ROM: rom})
+	if err != nil {
+		fmt.Printf("Error starting emulator: %s\n", err)
+		return
+	}
 
+	var crash *cpu.CrashReport
+	// Each tick runs one whole Game Boy frame and presents it once complete
 	f := func(screen *ebiten.Image) error {
-		for i := 0; i < CyclesPerFrame; i++ {
-			gameboy.HandleInterrupts()
-			cycles := gameboy.Step()
-			gameboy.RunFor(cycles)
+		if crash != nil {
+			return showCrash(screen, emulator, crash)
 		}
-
+		var buttons goboy.Buttons
 		for key, button := range keyMap {
-			if inpututil.IsKeyJustPressed(key) {
-				gameboy.PressButton(button)
+			if ebiten.IsKeyPressed(key) {
+				buttons |= button
 			}
-			if inpututil.IsKeyJustReleased(key) {
-				gameboy.ReleaseButton(button)
+		}
+		emulator.SetButtons(buttons)
+		if err := emulator.RunFrame(); err != nil {
+			report, ok := err.(*cpu.CrashReport)
+			if !ok {
+				return err
 			}
+			fmt.Println(report)
+			crash = report
+			return showCrash(screen, emulator, crash)
 		}
-		screen.ReplacePixels(display.Pixels())
+		screen.ReplacePixels(emulator.Framebuffer().(*image.RGBA).Pix)
 		return nil
 	}
 
@@ -61,7 +74,7 @@ func runGame(rom []byte) {
 	ebiten.SetRunnableInBackground(true)
 	err = ebiten.Run(f, constants.ScreenWidth, constants.ScreenHeight, constants.ScreenScaling, "Goboy")
 	if err != nil {
-		fmt.Sprintf("Exited main() with error: %s", err)
+		fmt.Printf("Exited main() with error: %s\n", err)
 	}
 }
 
@@ -82,7 +95,12 @@ func main() {
 
 	select {
 	case rom := <-romChannel:
-		runGame(rom.data)
+		data, err := romfile.Decode(rom.data, "")
+		if err != nil {
+			fmt.Printf("Error reading %s: %s\n", rom.name, err)
+			return
+		}
+		runGame(data)
 	}
 
 	return
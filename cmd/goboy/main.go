@@ -3,95 +3,192 @@ package main
 import (
 	"flag"
 	"fmt"
+	"image"
 	"io/ioutil"
 	"log"
 	"os"
-	"path/filepath"
+	"strings"
 
 	"github.com/hajimehoshi/ebiten"
-	"github.com/hajimehoshi/ebiten/inpututil"
+	"github.com/hajimehoshi/ebiten/ebitenutil"
+	"github.com/tbtommyb/goboy"
+	"github.com/tbtommyb/goboy/pkg/cartridge"
 	"github.com/tbtommyb/goboy/pkg/constants"
 	"github.com/tbtommyb/goboy/pkg/cpu"
-	"github.com/tbtommyb/goboy/pkg/display"
+	"github.com/tbtommyb/goboy/pkg/romfile"
 )
 
-var EbitenFPS = 60
-var CyclesPerFrame = cpu.GameboyClockSpeed / EbitenFPS
-
-var keyMap = map[ebiten.Key]cpu.Button{
-	ebiten.KeyZ:         cpu.ButtonA,
-	ebiten.KeyX:         cpu.ButtonB,
-	ebiten.KeyBackspace: cpu.ButtonSelect,
-	ebiten.KeyEnter:     cpu.ButtonStart,
-	ebiten.KeyRight:     cpu.ButtonRight,
-	ebiten.KeyLeft:      cpu.ButtonLeft,
-	ebiten.KeyUp:        cpu.ButtonUp,
-	ebiten.KeyDown:      cpu.ButtonDown,
+var keyMap = map[ebiten.Key]goboy.Buttons{
+	ebiten.KeyZ:         goboy.ButtonA,
+	ebiten.KeyX:         goboy.ButtonB,
+	ebiten.KeyBackspace: goboy.ButtonSelect,
+	ebiten.KeyEnter:     goboy.ButtonStart,
+	ebiten.KeyRight:     goboy.ButtonRight,
+	ebiten.KeyLeft:      goboy.ButtonLeft,
+	ebiten.KeyUp:        goboy.ButtonUp,
+	ebiten.KeyDown:      goboy.ButtonDown,
 }
 
-func main() {
-	var loadBIOS bool
-	var bios, rom []byte
-	var err error
+// Keys that tilt cartridges with an accelerometer, as x and y in g
+var tiltKeyMap = map[ebiten.Key][2]float64{
+	ebiten.KeyJ: {-1, 0},
+	ebiten.KeyL: {1, 0},
+	ebiten.KeyI: {0, -1},
+	ebiten.KeyK: {0, 1},
+}
 
-	ex, err := os.Executable()
-	if err != nil {
-		panic(err)
+// How far the tilt moves towards the keys each frame, so taps give a
+// gentle tilt
+const tiltRate = 0.1
+
+type tilt struct {
+	x, y float64
+}
+
+// fromKeys eases the tilt towards the direction held on the keyboard
+func (t *tilt) fromKeys() {
+	var x, y float64
+	for key, direction := range tiltKeyMap {
+		if ebiten.IsKeyPressed(key) {
+			x += direction[0]
+			y += direction[1]
+		}
 	}
+	t.x += clamp(x-t.x, -tiltRate, tiltRate)
+	t.y += clamp(y-t.y, -tiltRate, tiltRate)
+}
+
+// fromMouse tilts by how far the cursor is from the middle of the screen,
+// with the edges at 1g
+func (t *tilt) fromMouse() {
+	x, y := ebiten.CursorPosition()
+	t.x = clamp(float64(2*x-constants.ScreenWidth)/float64(constants.ScreenWidth), -1, 1)
+	t.y = clamp(float64(2*y-constants.ScreenHeight)/float64(constants.ScreenHeight), -1, 1)
+}
+
+func clamp(value, min, max float64) float64 {
+	switch {
+	case value < min:
+		return min
+	case value > max:
+		return max
+	}
+	return value
+}
+
+// showCrash draws the last frame with a note that emulation has stopped, so
+// the window stays open after a crash
+func showCrash(screen *ebiten.Image, emulator *goboy.Emulator, report *cpu.CrashReport) error {
+	screen.ReplacePixels(emulator.Framebuffer().(*image.RGBA).Pix)
+	return ebitenutil.DebugPrint(screen, fmt.Sprintf("Emulation stopped\nPC=%04X\nsee the log", report.PC))
+}
+
+// cheatFlags collects each -cheat
+type cheatFlags []string
+
+func (c *cheatFlags) String() string {
+	return strings.Join(*c, ",")
+}
+
+func (c *cheatFlags) Set(code string) error {
+	*c = append(*c, code)
+	return nil
+}
+
+func main() {
+	var bios []byte
+	var err error
 
 	biosPtr := flag.String("bios", "", "BIOS path to read from")
+	patchPtr := flag.String("patch", "", "IPS, UPS or BPS patch to apply, instead of one named after the ROM")
+	cameraPtr := flag.String("camera", "", "PNG or JPEG file, or directory of frames, for the Pocket Camera to see")
+	var cheats cheatFlags
+	flag.Var(&cheats, "cheat", "Game Genie or GameShark code to turn on, as well as those in the ROM's .cht file. May be repeated")
+	tiltPtr := flag.String("tilt", "keys", "control tilt sensor cartridges with the IJKL \"keys\" or the \"mouse\" position")
 	flag.Parse()
+	if *tiltPtr != "keys" && *tiltPtr != "mouse" {
+		log.Fatalf("Unknown -tilt %q, expected keys or mouse", *tiltPtr)
+	}
 
 	if len(flag.Args()) == 0 {
 		log.Fatalf("ROM path not provided")
 	}
 
 	if *biosPtr != "" {
-		bios, err = ioutil.ReadFile(filepath.Join(filepath.Dir(ex), *biosPtr))
+		bios, err = ioutil.ReadFile(*biosPtr)
 		if err != nil {
 			log.Fatalf("Error reading BIOS ROM %s", err.Error())
 		}
-		loadBIOS = true
 	}
 
-	rom, err = ioutil.ReadFile(filepath.Join(filepath.Dir(ex), flag.Args()[0]))
+	rom, err := romfile.LoadPatched(flag.Arg(0), *patchPtr)
 	if err != nil {
 		log.Fatalf("Error reading ROM %s", err.Error())
 	}
+	savePath := romfile.SavePath(flag.Arg(0))
 
-	fmt.Printf("%x\n", rom[:1000])
-	gameboy := cpu.Init(loadBIOS)
-	gameboy.LoadROM(rom)
-	if loadBIOS {
-		gameboy.LoadBIOS(bios)
+	var camera cartridge.ImageSource
+	if *cameraPtr != "" {
+		camera, err = cartridge.OpenImageSource(*cameraPtr)
+		if err != nil {
+			log.Fatalf("Error reading camera image %s", err.Error())
+		}
 	}
-	display := display.Init()
-	gameboy.AttachDisplay(display)
 
-	f := func(screen *ebiten.Image) error {
-		for i := 0; i < CyclesPerFrame; i++ {
-			gameboy.HandleInterrupts()
-			cycles := gameboy.Step()
-			gameboy.RunFor(cycles)
+	emulator, err := goboy.New(goboy.Options{ROM: rom, BIOS: bios, SavePath: savePath, SerialOutput: os.Stdout, Camera: camera})
+	if err != nil {
+		log.Fatalf("Error starting emulator %s", err.Error())
+	}
+	if err := emulator.Cheats().LoadFile(romfile.CheatsPath(flag.Arg(0))); err != nil {
+		log.Fatalf("Error reading cheats %s", err.Error())
+	}
+	for _, code := range cheats {
+		if err := emulator.Cheats().Add(code, ""); err != nil {
+			log.Fatalf("Error adding cheat %s", err.Error())
 		}
+	}
 
+	var t tilt
+	var crash *cpu.CrashReport
+	// Each tick runs one whole Game Boy frame and presents it once complete
+	f := func(screen *ebiten.Image) error {
+		if crash != nil {
+			return showCrash(screen, emulator, crash)
+		}
+		var buttons goboy.Buttons
 		for key, button := range keyMap {
-			if inpututil.IsKeyJustPressed(key) {
-				gameboy.PressButton(button)
+			if ebiten.IsKeyPressed(key) {
+				buttons |= button
 			}
-			if inpututil.IsKeyJustReleased(key) {
-				gameboy.ReleaseButton(button)
+		}
+		emulator.SetButtons(buttons)
+		if *tiltPtr == "mouse" {
+			t.fromMouse()
+		} else {
+			t.fromKeys()
+		}
+		emulator.SetAcceleration(t.x, t.y)
+		if err := emulator.RunFrame(); err != nil {
+			report, ok := err.(*cpu.CrashReport)
+			if !ok {
+				return err
 			}
+			log.Printf("%s", report)
+			crash = report
+			return showCrash(screen, emulator, crash)
 		}
-		screen.ReplacePixels(display.Pixels())
+		screen.ReplacePixels(emulator.Framebuffer().(*image.RGBA).Pix)
 		return nil
 	}
 
 	ebiten.SetWindowTitle("Goboy")
 	ebiten.SetRunnableInBackground(true)
 	err = ebiten.Run(f, constants.ScreenWidth, constants.ScreenHeight, constants.ScreenScaling, "Goboy")
+	if saveErr := emulator.Save(); saveErr != nil {
+		log.Printf("Error saving %s", saveErr.Error())
+	}
 	if err != nil {
-		fmt.Sprintf("Exited main() with error: %s", err)
+		log.Fatalf("Exited main() with error: %s", err)
 	}
 	return
 }
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/tbtommyb/goboy/pkg/cartridge"
+	"github.com/tbtommyb/goboy/pkg/romfile"
+)
+
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+	return "no"
+}
+
+func cgbSupport(h *cartridge.Header) string {
+	switch {
+	case h.RequiresCGB():
+		return fmt.Sprintf("required (%02X)", h.CGBFlag)
+	case h.SupportsCGB():
+		return fmt.Sprintf("supported (%02X)", h.CGBFlag)
+	}
+	return fmt.Sprintf("no (%02X)", h.CGBFlag)
+}
+
+func destination(h *cartridge.Header) string {
+	if h.Destination == cartridge.DestinationJapanese {
+		return "Japan"
+	}
+	return "overseas"
+}
+
+func printHeader(path string, h *cartridge.Header) {
+	fmt.Printf("%s\n", path)
+	fmt.Printf("  title:           %q\n", h.Title)
+	if h.ManufacturerCode != "" {
+		fmt.Printf("  manufacturer:    %q\n", h.ManufacturerCode)
+	}
+	fmt.Printf("  licensee:        %s\n", h.Licensee())
+	fmt.Printf("  CGB:             %s\n", cgbSupport(h))
+	fmt.Printf("  SGB:             %s\n", yesNo(h.SupportsSGB()))
+	fmt.Printf("  type:            %s (%02X)\n", h.TypeName(), h.CartridgeType)
+	if h.Multicart {
+		fmt.Printf("  multicart:       yes\n")
+	}
+	fmt.Printf("  battery:         %s\n", yesNo(h.Battery))
+	fmt.Printf("  RTC:             %s\n", yesNo(h.RTC))
+	fmt.Printf("  rumble:          %s\n", yesNo(h.Rumble))
+	fmt.Printf("  ROM size:        %d KB (%02X)\n", h.ROMSize/1024, h.ROMSizeCode)
+	if h.RAMSize >= 0 {
+		fmt.Printf("  RAM size:        %d KB (%02X)\n", h.RAMSize/1024, h.RAMSizeCode)
+	} else {
+		fmt.Printf("  RAM size:        unknown (%02X)\n", h.RAMSizeCode)
+	}
+	fmt.Printf("  destination:     %s\n", destination(h))
+	fmt.Printf("  version:         %d\n", h.Version)
+	fmt.Printf("  header checksum: %02X\n", h.HeaderChecksum)
+	fmt.Printf("  global checksum: %04X\n", h.GlobalChecksum)
+	fmt.Printf("  logo:            %s\n", map[bool]string{true: "valid", false: "invalid"}[h.LogoValid])
+	for _, problem := range h.Problems() {
+		fmt.Printf("  warning: %s\n", problem)
+	}
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s ROM...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	status := 0
+	for i, path := range flag.Args() {
+		if i > 0 {
+			fmt.Println()
+		}
+		data, err := romfile.Load(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading ROM %s\n", err.Error())
+			status = 1
+			continue
+		}
+		header, err := cartridge.Parse(data)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err.Error())
+			status = 1
+			continue
+		}
+		printHeader(path, header)
+		if len(header.Problems()) > 0 {
+			status = 1
+		}
+	}
+	os.Exit(status)
+}
@@ -0,0 +1,196 @@
+// Package goboy runs a Game Boy one frame at a time, for embedding the
+// emulator in frontends and tools.
+package goboy
+
+import (
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/tbtommyb/goboy/pkg/cartridge"
+	"github.com/tbtommyb/goboy/pkg/cheat"
+	"github.com/tbtommyb/goboy/pkg/cpu"
+	"github.com/tbtommyb/goboy/pkg/display"
+)
+
+type Options struct {
+	ROM []byte
+	// BIOS is optional. Without one the state after the boot ROM is set up
+	// directly.
+	BIOS  []byte
+	Model cpu.Model
+	// Palette holds the colours of the four shades, lightest first. Unset
+	// colours use the standard greys.
+	Palette [4]color.Color
+	// SavePath is where battery-backed cartridge RAM is loaded from and
+	// saved to. Leave it empty to disable saving.
+	SavePath string
+	// SerialOutput receives bytes sent over the serial port, which is how
+	// test ROMs report results. Leave it nil to discard them.
+	SerialOutput io.Writer
+	// Infrared is connected to the IR port of cartridges that have one. Use
+	// cartridge.NewInfraredLink to connect two emulators.
+	Infrared cartridge.Infrared
+	// Camera is what the Pocket Camera sees. Use cartridge.OpenImageSource
+	// to show it a picture or a directory of frames. Without one it sees
+	// black.
+	Camera cartridge.ImageSource
+}
+
+// Buttons holds one bit for each button, set while the button is held
+type Buttons byte
+
+const (
+	ButtonRight  Buttons = 1 << cpu.ButtonRight
+	ButtonLeft   Buttons = 1 << cpu.ButtonLeft
+	ButtonUp     Buttons = 1 << cpu.ButtonUp
+	ButtonDown   Buttons = 1 << cpu.ButtonDown
+	ButtonA      Buttons = 1 << cpu.ButtonA
+	ButtonB      Buttons = 1 << cpu.ButtonB
+	ButtonSelect Buttons = 1 << cpu.ButtonSelect
+	ButtonStart  Buttons = 1 << cpu.ButtonStart
+)
+
+type Emulator struct {
+	options Options
+	cpu     *cpu.CPU
+	display *display.Display
+	buttons Buttons
+	crash   *cpu.CrashReport
+
+	cheats       *cheat.Manager
+	cheatVersion uint
+	gameShark    []cheat.Cheat
+}
+
+// New returns an emulator ready to run the ROM. ROMs that can't be loaded
+// give errors wrapping cartridge.ErrTruncatedROM, cartridge.ErrHeaderMismatch
+// or cartridge.ErrUnsupportedMapper.
+func New(options Options) (*Emulator, error) {
+	e := &Emulator{options: options, cheats: cheat.NewManager()}
+	if err := e.Reset(); err != nil {
+		return nil, err
+	}
+	if options.SavePath != "" {
+		data, err := ioutil.ReadFile(options.SavePath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrap(err, "couldn't read save file")
+		}
+		e.cpu.LoadSaveData(data)
+	}
+	return e, nil
+}
+
+// Reset restarts the machine as if it had been switched off and on.
+// Cartridge RAM is kept.
+func (e *Emulator) Reset() error {
+	gameboy := cpu.Init(e.options.BIOS != nil)
+	gameboy.SetModel(e.options.Model)
+	gameboy.SetPalette(e.options.Palette)
+	gameboy.SetSerialOutput(e.options.SerialOutput)
+	if err := gameboy.LoadROM(e.options.ROM); err != nil {
+		return errors.Wrap(err, "couldn't load ROM")
+	}
+	if e.options.Infrared != nil {
+		gameboy.ConnectInfrared(e.options.Infrared)
+	}
+	if e.options.Camera != nil {
+		gameboy.ConnectCamera(e.options.Camera)
+	}
+	if e.options.BIOS != nil {
+		gameboy.LoadBIOS(e.options.BIOS)
+	}
+	if e.cpu != nil {
+		gameboy.LoadSaveData(e.cpu.SaveData())
+	}
+	e.display = display.Init()
+	gameboy.AttachDisplay(e.display)
+	e.cpu = gameboy
+	e.buttons = 0
+	e.crash = nil
+	e.updateCheats(true)
+	return nil
+}
+
+// RunFrame runs until the display enters VBlank. With the LCD off there is
+// no VBlank, so a frame also ends after a frame's worth of clocks.
+//
+// If emulation can't continue RunFrame returns a *cpu.CrashReport with the
+// state of the CPU, and keeps returning it until Reset.
+func (e *Emulator) RunFrame() (err error) {
+	if e.crash != nil {
+		return e.crash
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			e.crash = e.cpu.ReportCrash(r)
+			err = e.crash
+		}
+	}()
+
+	e.updateCheats(false)
+	frame, start := e.cpu.FrameCount(), e.cpu.Clocks()
+	for e.cpu.FrameCount() == frame && e.cpu.Clocks()-start < cpu.ClocksPerFrame {
+		e.cpu.HandleInterrupts()
+		e.cpu.Step()
+	}
+	e.cpu.ApplyGameShark(e.gameShark)
+	return nil
+}
+
+// Cheats returns the cheats for the game, which can be changed while it runs.
+// Changes take effect from the next frame. GameShark codes are written as
+// each frame ends, as the device does at VBlank.
+func (e *Emulator) Cheats() *cheat.Manager {
+	return e.cheats
+}
+
+// updateCheats passes on any changes to the cheats
+func (e *Emulator) updateCheats(force bool) {
+	version := e.cheats.Version()
+	if version == e.cheatVersion && !force {
+		return
+	}
+	e.cheatVersion = version
+	e.cpu.SetGameGenie(e.cheats.Enabled(cheat.GameGenie))
+	e.gameShark = e.cheats.Enabled(cheat.GameShark)
+}
+
+// Framebuffer returns the last complete frame as an *image.RGBA. It is
+// reused, so copy it to keep a frame past the next call to RunFrame.
+func (e *Emulator) Framebuffer() image.Image {
+	return e.display.Image()
+}
+
+// SetButtons sets which buttons are held
+func (e *Emulator) SetButtons(buttons Buttons) {
+	for button := cpu.ButtonRight; button <= cpu.ButtonStart; button++ {
+		bit := Buttons(1 << button)
+		switch {
+		case buttons&bit != 0 && e.buttons&bit == 0:
+			e.cpu.PressButton(button)
+		case buttons&bit == 0 && e.buttons&bit != 0:
+			e.cpu.ReleaseButton(button)
+		}
+	}
+	e.buttons = buttons
+}
+
+// SetAcceleration tilts cartridges with an accelerometer, such as Kirby Tilt
+// 'n' Tumble. x and y are in g, with positive x tilting right and positive y
+// tilting down. Other cartridges ignore it.
+func (e *Emulator) SetAcceleration(x, y float64) {
+	e.cpu.SetAcceleration(x, y)
+}
+
+// Save writes battery-backed cartridge RAM to the save path
+func (e *Emulator) Save() error {
+	data := e.cpu.SaveData()
+	if e.options.SavePath == "" || data == nil {
+		return nil
+	}
+	return errors.Wrap(ioutil.WriteFile(e.options.SavePath, data, 0644), "couldn't write save file")
+}
@@ -0,0 +1,363 @@
+package goboy
+
+import (
+	"bytes"
+	"hash/fnv"
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/tbtommyb/goboy/pkg/cartridge"
+	c "github.com/tbtommyb/goboy/pkg/constants"
+	"github.com/tbtommyb/goboy/pkg/cpu"
+	"github.com/tbtommyb/goboy/pkg/memory"
+)
+
+// createROM returns a ROM that loops forever at the entry point
+func createROM(cartridgeType, ramSize byte) []byte {
+	rom := make([]byte, 0x8000)
+	rom[0x100] = 0x18 // JR -2
+	rom[0x101] = 0xFE
+	rom[cartridge.CartridgeTypeAddress] = cartridgeType
+	rom[cartridge.RAMSizeAddress] = ramSize
+	return rom
+}
+
+func TestRunFrameEndsAtVBlank(t *testing.T) {
+	e, err := New(Options{ROM: createROM(0, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := uint(1); i <= 3; i++ {
+		e.RunFrame()
+		if frames := e.cpu.FrameCount(); frames != i {
+			t.Errorf("Expected frame count %d, got %d", i, frames)
+		}
+		if ly := e.cpu.ReadIO(c.LYAddress); ly != 144 {
+			t.Errorf("Expected LY 144, got %d", ly)
+		}
+	}
+}
+
+func TestRunFrameWithLCDOff(t *testing.T) {
+	e, err := New(Options{ROM: createROM(0, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.cpu.WriteLCDControl(0)
+	e.RunFrame()
+	if frames := e.cpu.FrameCount(); frames != 0 {
+		t.Errorf("Expected frame count 0, got %d", frames)
+	}
+}
+
+func TestRunFrameWithLCDOffCountsInterrupts(t *testing.T) {
+	rom := createROM(0, 0)
+	copy(rom[0x100:], []byte{0xFB, 0x76, 0x18, 0xFD}) // EI, HALT, JR -3
+	rom[0x50] = 0xD9                                  // RETI
+	e, err := New(Options{ROM: rom})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.cpu.WriteLCDControl(0)
+	// Overflow the timer every 16 clocks
+	e.cpu.WriteMem(c.TMAAddress, 0xFF)
+	e.cpu.WriteMem(c.TACAddress, 0x05)
+	e.cpu.WriteMem(c.InterruptEnableAddress, 0x04)
+
+	start := e.cpu.Clocks()
+	e.RunFrame()
+	if clocks := e.cpu.Clocks() - start; clocks < cpu.ClocksPerFrame || clocks > cpu.ClocksPerFrame+32 {
+		t.Errorf("Expected a frame of %d clocks, got %d", cpu.ClocksPerFrame, clocks)
+	}
+}
+
+func TestNewRejectsUnsupportedROM(t *testing.T) {
+	_, err := New(Options{ROM: createROM(0x13, 0)})
+	if errors.Cause(err) != memory.ErrUnsupportedMapper {
+		t.Errorf("Expected %v, got %v", memory.ErrUnsupportedMapper, err)
+	}
+}
+
+func TestRunFrameReportsCrash(t *testing.T) {
+	rom := createROM(0, 0)
+	rom[0x100] = 0xD3 // invalid opcode
+	e, err := New(Options{ROM: rom})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = e.RunFrame()
+	report, ok := err.(*cpu.CrashReport)
+	if !ok {
+		t.Fatalf("Expected a crash report, got %v", err)
+	}
+	if report.PC != 0x101 {
+		t.Errorf("Expected crash at PC %x, got %x", 0x101, report.PC)
+	}
+	if again := e.RunFrame(); again != err {
+		t.Errorf("Expected the same crash report again, got %v", again)
+	}
+	if err := e.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if e.crash != nil {
+		t.Errorf("Expected Reset to clear the crash")
+	}
+}
+
+func TestSetButtons(t *testing.T) {
+	testCases := []struct {
+		buttons  Buttons
+		expected byte
+	}{
+		{0, 0x1F},
+		{ButtonA, 0x1E},
+		{ButtonA | ButtonStart, 0x16},
+		{ButtonStart | ButtonUp, 0x17},
+		{0, 0x1F},
+	}
+	e, err := New(Options{ROM: createROM(0, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.cpu.WriteJoypad(0x10)
+	for _, testCase := range testCases {
+		e.SetButtons(testCase.buttons)
+		if actual := e.cpu.ReadJoypad(); actual != testCase.expected {
+			t.Errorf("Buttons %08b: expected %x, got %x", testCase.buttons, testCase.expected, actual)
+		}
+	}
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goboy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	options := Options{ROM: createROM(3, 2), SavePath: filepath.Join(dir, "game.sav")}
+
+	e, err := New(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.cpu.WriteMem(0x0000, 0x0A)
+	e.cpu.WriteMem(0xA000, 0x42)
+	if err := e.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err = New(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := e.cpu.SaveData()[0]; actual != 0x42 {
+		t.Errorf("Expected %x, got %x", 0x42, actual)
+	}
+	e.Reset()
+	if actual := e.cpu.SaveData()[0]; actual != 0x42 {
+		t.Errorf("Expected %x after reset, got %x", 0x42, actual)
+	}
+}
+
+func TestInfraredLink(t *testing.T) {
+	left, right := cartridge.NewInfraredLink()
+	e, err := New(Options{ROM: createROM(0xFF, 2), Infrared: left})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.cpu.WriteMem(0x0000, 0x0E)
+	e.cpu.WriteMem(0xA000, 0x01)
+	if !right.Light() {
+		t.Errorf("Expected the LED to light the other end")
+	}
+	e.cpu.WriteMem(0xA000, 0x00)
+	if right.Light() {
+		t.Errorf("Expected the LED to turn off")
+	}
+}
+
+func TestCheats(t *testing.T) {
+	rom := createROM(0, 0)
+	copy(rom[0x100:], []byte{0xC3, 0x50, 0x01}) // JP 0150
+	copy(rom[0x150:], []byte{
+		0xFA, 0x60, 0x01, // LD A,(0160)
+		0x47,             // LD B,A
+		0xFA, 0x00, 0xC0, // LD A,(C000)
+		0x4F,       // LD C,A
+		0x18, 0xF6, // JR 0150
+	})
+	rom[0x160] = 0x11
+	e, err := New(Options{ROM: rom})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Cheats().Add("771-60F-A0E", "Patch ROM"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Cheats().Add("015500C0", "Write RAM"); err != nil {
+		t.Fatal(err)
+	}
+	e.RunFrame()
+	e.RunFrame()
+	if actual := e.cpu.GetBC(); actual != 0x7755 {
+		t.Errorf("Expected BC %04x, got %04x", 0x7755, actual)
+	}
+
+	e.Cheats().SetEnabled("771-60F-A0E", false)
+	e.RunFrame()
+	if actual := e.cpu.GetBC(); actual != 0x1155 {
+		t.Errorf("Expected BC %04x once the Game Genie code is off, got %04x", 0x1155, actual)
+	}
+}
+
+type runResult struct {
+	output string
+	frame  uint32
+}
+
+func runROM(path string, frames int) (runResult, error) {
+	rom, err := ioutil.ReadFile(path)
+	if err != nil {
+		return runResult{}, err
+	}
+	var output bytes.Buffer
+	e, err := New(Options{ROM: rom, SerialOutput: &output})
+	if err != nil {
+		return runResult{}, err
+	}
+	for i := 0; i < frames; i++ {
+		if err := e.RunFrame(); err != nil {
+			return runResult{}, err
+		}
+	}
+	hash := fnv.New32a()
+	hash.Write(e.Framebuffer().(*image.RGBA).Pix)
+	return runResult{output: output.String(), frame: hash.Sum32()}, nil
+}
+
+// TestConcurrentEmulators runs two copies of several ROMs at once and checks
+// that each produces its own output and that copies match. Run it with -race
+// to check emulators share no state.
+func TestConcurrentEmulators(t *testing.T) {
+	roms := []string{
+		"specs/cpu_instrs/06-ld r,r.gb",
+		"specs/cpu_instrs/10-bit ops.gb",
+		"specs/instr_timing.gb",
+	}
+	const copies = 2
+	frames := 200
+	if testing.Short() {
+		frames = 20
+	}
+
+	results := make([][copies]runResult, len(roms))
+	var wg sync.WaitGroup
+	for i := range roms {
+		for j := 0; j < copies; j++ {
+			wg.Add(1)
+			go func(i, j int) {
+				defer wg.Done()
+				result, err := runROM(roms[i], frames)
+				if err != nil {
+					t.Error(err)
+				}
+				results[i][j] = result
+			}(i, j)
+		}
+	}
+	wg.Wait()
+
+	for i, rom := range roms {
+		name := strings.TrimSuffix(filepath.Base(rom), ".gb")
+		first := results[i][0]
+		if !strings.HasPrefix(first.output, name) {
+			t.Errorf("%s: expected output to start with its name, got %q", rom, first.output)
+		}
+		for j := 1; j < copies; j++ {
+			if results[i][j] != first {
+				t.Errorf("%s: copy %d differs: %q, frame %x; expected %q, frame %x",
+					rom, j, results[i][j].output, results[i][j].frame, first.output, first.frame)
+			}
+		}
+	}
+}
+
+// TestOAMBug runs blargg's oam_bug ROMs. 7-timing_effect is left out: the
+// output it expects from hardware is over 10KB, but its shell writes text
+// from 0xA004 with no bound, so it runs past the 8KB of cartridge RAM into
+// the code it copied to WRAM and crashes before it checks the result.
+func TestOAMBug(t *testing.T) {
+	if testing.Short() {
+		t.Skip("oam_bug ROMs take a few seconds")
+	}
+	roms := []string{
+		"specs/oam_bug/1-lcd_sync.gb",
+		"specs/oam_bug/2-causes.gb",
+		"specs/oam_bug/3-non_causes.gb",
+		"specs/oam_bug/4-scanline_timing.gb",
+		"specs/oam_bug/5-timing_bug.gb",
+		"specs/oam_bug/6-timing_no_bug.gb",
+		"specs/oam_bug/8-instr_effect.gb",
+	}
+	for _, rom := range roms {
+		result, err := runROM(rom, 400)
+		if err != nil {
+			t.Errorf("%s: %v", rom, err)
+		} else if !strings.Contains(result.output, "Passed") {
+			t.Errorf("%s: expected to pass, got %q", rom, result.output)
+		}
+	}
+}
+
+// runMooneye runs the mooneye-test-suite ROMs in dir, skipping if none have
+// been copied there. They pass by loading the Fibonacci numbers into BC, DE
+// and HL.
+func runMooneye(t *testing.T, dir string) {
+	roms, _ := filepath.Glob(filepath.Join(dir, "*.gb"))
+	if len(roms) == 0 {
+		t.Skipf("mooneye ROMs not found in %s", dir)
+	}
+	for _, path := range roms {
+		rom, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := New(Options{ROM: rom})
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		for i := 0; i < 300; i++ {
+			if err := e.RunFrame(); err != nil {
+				t.Errorf("%s: %v", path, err)
+				break
+			}
+		}
+		bc, de, hl := e.cpu.GetBC(), e.cpu.GetDE(), e.cpu.GetHL()
+		if bc != 0x0305 || de != 0x080D || hl != 0x1522 {
+			t.Errorf("%s: expected BC 0305 DE 080D HL 1522, got BC %04X DE %04X HL %04X", path, bc, de, hl)
+		}
+	}
+}
+
+// TestMooneyeMBC1 runs mooneye-test-suite's emulator-only/mbc1 ROMs
+func TestMooneyeMBC1(t *testing.T) {
+	runMooneye(t, "specs/mooneye/mbc1")
+}
+
+// TestMooneyeTimer runs mooneye-test-suite's acceptance/timer ROMs
+func TestMooneyeTimer(t *testing.T) {
+	runMooneye(t, "specs/mooneye/timer")
+}
+
+// TestMooneyeSTAT runs mooneye-test-suite's acceptance/ppu ROMs for DMG
+func TestMooneyeSTAT(t *testing.T) {
+	runMooneye(t, "specs/mooneye/ppu")
+}
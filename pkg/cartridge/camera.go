@@ -0,0 +1,182 @@
+package cartridge
+
+const (
+	cameraRAMSize      = 0x20000
+	cameraRegisterBank = 0x10
+	cameraRegisterMask = 0x7F
+	cameraRegisters    = 0x36
+	cameraImageStart   = 0x100
+)
+
+// M64282FP registers, as the cartridge maps them into bank 0x10
+const (
+	cameraControl      = 0x0
+	cameraEdgeAndGain  = 0x1
+	cameraExposureHigh = 0x2
+	cameraExposureLow  = 0x3
+	cameraEdgeRatio    = 0x4
+	cameraMatrix       = 0x6
+)
+
+// Capture takes 32446 M-cycles plus 16 per step of exposure, and 512 more
+// unless the N bit is set
+const (
+	cameraCaptureClocks   = 129784
+	cameraNClocks         = 2048
+	cameraExposureClocks  = 64
+	cameraControlReadMask = 0x07
+)
+
+// Camera is a mapper with an image sensor
+type Camera interface {
+	ConnectCamera(source ImageSource)
+}
+
+// Clocked is a mapper that needs to know how much time has passed. clock
+// returns the number of clocks since power on.
+type Clocked interface {
+	ConnectClock(clock func() uint64)
+}
+
+// camera is the Pocket Camera's controller. It maps 1MB of ROM, 128KB of RAM
+// and, in RAM bank 0x10, the M64282FP sensor's registers. Captures write a
+// 128x112 picture as tiles to 0xA100-0xAEFF in RAM bank 0.
+type camera struct {
+	rom        []byte
+	ram        []byte
+	romBank    uint
+	ramBank    uint
+	ramEnabled bool
+	registers  [cameraRegisters]byte
+
+	source     ImageSource
+	clock      func() uint64
+	capturing  bool
+	captureEnd uint64
+	captured   [SensorWidth * SensorHeight / 4]byte
+}
+
+func newCamera(rom []byte, header *Header) (Mapper, error) {
+	return &camera{rom: rom, ram: make([]byte, cameraRAMSize)}, nil
+}
+
+func (m *camera) ConnectCamera(source ImageSource) {
+	m.source = source
+}
+
+func (m *camera) ConnectClock(clock func() uint64) {
+	m.clock = clock
+}
+
+func (m *camera) ReadROM(address uint16) byte {
+	if address < ROMBankSize {
+		return m.rom[address]
+	}
+	return m.rom[romBankAddress(m.rom, m.romBank, address)]
+}
+
+func (m *camera) WriteControl(address uint16, value byte) {
+	switch {
+	case address < RAMEnableLimit:
+		m.ramEnabled = value&0xF == 0xA
+	case address < ROMBankNumberLimit:
+		// Unlike most controllers, bank 0 can be mapped here
+		m.romBank = uint(value & 0x3F)
+	case address < RAMBankNumberLimit:
+		m.ramBank = uint(value & 0x1F)
+	}
+}
+
+func (m *camera) ReadRAM(address uint16) byte {
+	m.update()
+	if m.ramBank&cameraRegisterBank != 0 {
+		// Only the control register can be read back
+		if address&cameraRegisterMask == cameraControl {
+			return m.registers[cameraControl] & cameraControlReadMask
+		}
+		return 0x00
+	}
+	if m.capturing {
+		return 0x00
+	}
+	return m.ram[ramBankAddress(m.ram, m.ramBank&0xF, address)]
+}
+
+func (m *camera) WriteRAM(address uint16, value byte) {
+	m.update()
+	if m.ramBank&cameraRegisterBank != 0 {
+		m.writeRegister(byte(address&cameraRegisterMask), value)
+		return
+	}
+	if m.ramEnabled && !m.capturing {
+		m.ram[ramBankAddress(m.ram, m.ramBank&0xF, address)] = value
+	}
+}
+
+func (m *camera) writeRegister(register, value byte) {
+	if register >= cameraRegisters {
+		return
+	}
+	if register != cameraControl {
+		m.registers[register] = value
+		return
+	}
+	m.registers[cameraControl] = value & 0x6
+	if value&0x1 != 0 && !m.capturing {
+		m.startCapture()
+	}
+}
+
+// startCapture processes the picture straight away using the registers as
+// they are now, and makes it visible once the capture time has passed
+func (m *camera) startCapture() {
+	var picture *sensorImage
+	if m.source != nil {
+		picture = newSensorImage(m.source.Frame())
+	} else {
+		picture = &sensorImage{}
+	}
+	m.captured = capture(picture, &m.registers)
+	m.capturing = true
+	m.registers[cameraControl] |= 0x1
+	if m.clock != nil {
+		m.captureEnd = m.clock() + m.captureClocks()
+	}
+	m.update()
+}
+
+func (m *camera) exposure() uint {
+	return uint(m.registers[cameraExposureHigh])<<8 | uint(m.registers[cameraExposureLow])
+}
+
+func (m *camera) captureClocks() uint64 {
+	clocks := cameraCaptureClocks + cameraExposureClocks*uint64(m.exposure())
+	if m.registers[cameraEdgeAndGain]&0x80 == 0 {
+		clocks += cameraNClocks
+	}
+	return clocks
+}
+
+// update finishes the capture once its time is up. Without a clock,
+// captures finish at once.
+func (m *camera) update() {
+	if !m.capturing || (m.clock != nil && m.clock() < m.captureEnd) {
+		return
+	}
+	m.capturing = false
+	m.registers[cameraControl] &^= 0x1
+	copy(m.ram[cameraImageStart:], m.captured[:])
+}
+
+func (m *camera) WriteRAMBank(bank uint, address uint16, value byte) {
+	writeRAMBank(m.ram, bank, address, value)
+}
+
+func (m *camera) SaveData() []byte {
+	m.update()
+	return saveRAM(m.ram, true)
+}
+
+func (m *camera) LoadSaveData(data []byte) {
+	copy(m.ram, data)
+}
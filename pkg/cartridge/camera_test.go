@@ -0,0 +1,195 @@
+package cartridge
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+type testImage struct {
+	picture image.Image
+}
+
+func (s testImage) Frame() image.Image {
+	return s.picture
+}
+
+// createPicture returns a picture twice the sensor's size with the left and
+// right halves at different levels
+func createPicture(left, right byte) image.Image {
+	picture := image.NewGray(image.Rect(0, 0, 2*SensorWidth, 2*SensorHeight))
+	for y := 0; y < 2*SensorHeight; y++ {
+		for x := 0; x < 2*SensorWidth; x++ {
+			level := left
+			if x >= SensorWidth {
+				level = right
+			}
+			picture.SetGray(x, y, color.Gray{Y: level})
+		}
+	}
+	return picture
+}
+
+func createCamera() *camera {
+	header := &Header{Features: Features{Controller: PocketCamera}}
+	mapper, _ := newCamera(createBankedROM(0x100000), header)
+	m := mapper.(*camera)
+	m.WriteControl(0x0000, 0x0A)
+	return m
+}
+
+// setMatrix gives every pixel in the dithering matrix the same thresholds
+func setMatrix(m *camera, low, middle, high byte) {
+	m.WriteControl(0x4000, cameraRegisterBank)
+	for i := uint16(0); i < 16; i++ {
+		m.WriteRAM(0xA006+3*i, low)
+		m.WriteRAM(0xA007+3*i, middle)
+		m.WriteRAM(0xA008+3*i, high)
+	}
+}
+
+// shadeAt reads a pixel of the captured picture back out of the tiles in RAM
+func shadeAt(m *camera, x, y int) byte {
+	m.WriteControl(0x4000, 0x00)
+	row := uint16(0xA100 + ((y/8)*16+x/8)*16 + (y%8)*2)
+	bit := uint(7 - x%8)
+	return (m.ReadRAM(row)>>bit)&0x1 | ((m.ReadRAM(row+1)>>bit)&0x1)<<1
+}
+
+func TestCameraBanking(t *testing.T) {
+	m := createCamera()
+	m.WriteControl(0x2000, 0x00)
+	if actual := m.ReadROM(0x4000); actual != 0x00 {
+		t.Errorf("Expected bank 0 to be mappable, got bank %x", actual)
+	}
+	m.WriteControl(0x2000, 0x7F)
+	if actual := m.ReadROM(0x4000); actual != 0x3F {
+		t.Errorf("Expected bank %x, got %x", 0x3F, actual)
+	}
+
+	for bank := byte(0); bank < 16; bank++ {
+		m.WriteControl(0x4000, bank)
+		m.WriteRAM(0xB000, 0x30+bank)
+	}
+	m.WriteControl(0x4000, 0x0F)
+	if actual := m.ReadRAM(0xB000); actual != 0x3F {
+		t.Errorf("Expected RAM bank %x to read %x, got %x", 0x0F, 0x3F, actual)
+	}
+	m.WriteControl(0x0000, 0x00)
+	m.WriteRAM(0xB000, 0x00)
+	if actual := m.ReadRAM(0xB000); actual != 0x3F {
+		t.Errorf("Expected RAM to be read-only while disabled, got %x", actual)
+	}
+
+	m.WriteControl(0x4000, cameraRegisterBank)
+	m.WriteRAM(0xA001, 0xE4)
+	if actual := m.ReadRAM(0xA001); actual != 0x00 {
+		t.Errorf("Expected sensor registers to read %x, got %x", 0x00, actual)
+	}
+	m.WriteRAM(0xA080, 0x06)
+	if actual := m.ReadRAM(0xA000); actual != 0x06 {
+		t.Errorf("Expected registers to mirror every %x bytes and read %x, got %x", 0x80, 0x06, actual)
+	}
+	if data := m.SaveData(); len(data) != 0x20000 || data[0x1F000] != 0x3F {
+		t.Errorf("Expected 128KB of save data with %x at %x", 0x3F, 0x1F000)
+	}
+}
+
+func TestCameraCapture(t *testing.T) {
+	var now uint64
+	m := createCamera()
+	m.ConnectClock(func() uint64 { return now })
+	m.ConnectCamera(testImage{createPicture(0x90, 0x90)})
+	setMatrix(m, 0x40, 0x80, 0xC0)
+	m.WriteRAM(0xA001, 0x80)
+	m.WriteRAM(0xA002, 0x10)
+	m.WriteRAM(0xA003, 0x00)
+	m.WriteRAM(0xA000, 0x01)
+
+	if actual := m.ReadRAM(0xA000); actual != 0x01 {
+		t.Errorf("Expected the capture to be in progress, got %x", actual)
+	}
+	m.WriteControl(0x4000, 0x00)
+	if actual := m.ReadRAM(0xA100); actual != 0x00 {
+		t.Errorf("Expected RAM to read %x during a capture, got %x", 0x00, actual)
+	}
+
+	now = cameraCaptureClocks + 0x1000*cameraExposureClocks - 1
+	m.WriteControl(0x4000, cameraRegisterBank)
+	if actual := m.ReadRAM(0xA000); actual != 0x01 {
+		t.Errorf("Expected the capture to take until clock %d, got %x", now+1, actual)
+	}
+	now++
+	if actual := m.ReadRAM(0xA000); actual != 0x00 {
+		t.Errorf("Expected the capture to have finished, got %x", actual)
+	}
+	if actual := shadeAt(m, 0, 0); actual != 1 {
+		t.Errorf("Expected shade 1, got %d", actual)
+	}
+	if actual := shadeAt(m, 127, 111); actual != 1 {
+		t.Errorf("Expected shade 1 in the last pixel, got %d", actual)
+	}
+}
+
+func TestCameraPipeline(t *testing.T) {
+	testCases := []struct {
+		name        string
+		exposure    uint16
+		edge        byte
+		flat        byte
+		left, right byte
+	}{
+		{"unit exposure", 0x1000, 0x00, 2, 2, 1},
+		{"half exposure", 0x0800, 0x00, 3, 3, 2},
+		{"double exposure", 0x2000, 0x00, 0, 0, 0},
+		{"vertical edges only", 0x1000, 0xC0, 2, 2, 1},
+		{"horizontal edges", 0x1000, 0xA0, 2, 3, 0},
+	}
+	for _, test := range testCases {
+		m := createCamera()
+		m.ConnectCamera(testImage{createPicture(0x60, 0xA0)})
+		setMatrix(m, 0x40, 0x80, 0xC0)
+		m.WriteRAM(0xA001, test.edge)
+		m.WriteRAM(0xA002, byte(test.exposure>>8))
+		m.WriteRAM(0xA003, byte(test.exposure))
+		m.WriteRAM(0xA004, 0x20)
+		m.WriteRAM(0xA000, 0x01)
+
+		if actual := shadeAt(m, 63, 50); actual != test.left {
+			t.Errorf("%s: expected shade %d left of the edge, got %d", test.name, test.left, actual)
+		}
+		if actual := shadeAt(m, 64, 50); actual != test.right {
+			t.Errorf("%s: expected shade %d right of the edge, got %d", test.name, test.right, actual)
+		}
+		if actual := shadeAt(m, 10, 50); actual != test.flat {
+			t.Errorf("%s: expected shade %d away from the edge, got %d", test.name, test.flat, actual)
+		}
+	}
+}
+
+func TestCameraMatrix(t *testing.T) {
+	m := createCamera()
+	m.ConnectCamera(testImage{createPicture(0x80, 0x80)})
+	setMatrix(m, 0x40, 0x80, 0xC0)
+	// Raise the thresholds for the pixel at (1, 2) in each 4x4 block
+	m.WriteRAM(0xA006+3*(2*4+1), 0xF0)
+	m.WriteRAM(0xA007+3*(2*4+1), 0xF0)
+	m.WriteRAM(0xA008+3*(2*4+1), 0xF0)
+	m.WriteRAM(0xA002, 0x10)
+	m.WriteRAM(0xA000, 0x01)
+
+	testCases := []struct {
+		x, y     int
+		expected byte
+	}{
+		{0, 0, 1},
+		{1, 2, 3},
+		{5, 6, 3},
+		{5, 7, 1},
+	}
+	for _, test := range testCases {
+		if actual := shadeAt(m, test.x, test.y); actual != test.expected {
+			t.Errorf("Pixel (%d, %d): expected shade %d, got %d", test.x, test.y, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package cartridge
+
+const (
+	eepromWords       = 128
+	eepromAddressBits = 8
+	eepromWordBits    = 16
+)
+
+// 93LC56 opcodes, sent after the start bit
+const (
+	eepromExtended = 0x0
+	eepromWrite    = 0x1
+	eepromRead     = 0x2
+	eepromErase    = 0x3
+)
+
+// Extended opcodes, in the top two bits of the address
+const (
+	eepromDisableWrites = 0x0
+	eepromWriteAll      = 0x1
+	eepromEraseAll      = 0x2
+	eepromEnableWrites  = 0x3
+)
+
+type eepromState byte
+
+const (
+	eepromIdle eepromState = iota
+	eepromCommand
+	eepromReading
+	eepromWriting
+)
+
+// eeprom is a 93LC56 serial EEPROM in 16-bit mode: 128 words read and
+// written one bit at a time. The game drives chip select, the clock and data
+// in, and each command is a start bit, a 2-bit opcode and an 8-bit address.
+type eeprom struct {
+	data [eepromWords]uint16
+
+	cs, clk, di, do bool
+	state           eepromState
+	writeEnabled    bool
+	writeAll        bool
+	shift           uint16
+	bits            uint
+	address         byte
+}
+
+func (e *eeprom) read() byte {
+	var value byte
+	if e.cs {
+		value |= 0x80
+	}
+	if e.clk {
+		value |= 0x40
+	}
+	if e.di {
+		value |= 0x02
+	}
+	if e.do {
+		value |= 0x01
+	}
+	return value
+}
+
+func (e *eeprom) write(value byte) {
+	cs, clk, di := value&0x80 != 0, value&0x40 != 0, value&0x02 != 0
+	switch {
+	case !cs:
+		e.state = eepromIdle
+	case !e.cs:
+		// Writes finish at once, so DO always reports ready
+		e.do = true
+	}
+	risingEdge := cs && clk && !e.clk
+	e.cs, e.clk, e.di = cs, clk, di
+	if risingEdge {
+		e.clock()
+	}
+}
+
+func (e *eeprom) clock() {
+	switch e.state {
+	case eepromIdle:
+		if e.di {
+			e.state = eepromCommand
+			e.shift, e.bits = 0, 0
+		}
+	case eepromCommand:
+		e.shiftIn()
+		if e.bits == 2+eepromAddressBits {
+			e.runCommand(byte(e.shift>>eepromAddressBits), byte(e.shift))
+		}
+	case eepromReading:
+		// Sequential reads carry on into the next word
+		if e.bits == eepromWordBits {
+			e.address = (e.address + 1) % eepromWords
+			e.shift, e.bits = e.data[e.address], 0
+		}
+		e.do = e.shift&0x8000 != 0
+		e.shift <<= 1
+		e.bits++
+	case eepromWriting:
+		e.shiftIn()
+		if e.bits == eepromWordBits {
+			e.store(e.shift)
+			e.state = eepromIdle
+		}
+	}
+}
+
+func (e *eeprom) shiftIn() {
+	e.shift <<= 1
+	if e.di {
+		e.shift |= 1
+	}
+	e.bits++
+}
+
+func (e *eeprom) runCommand(opcode, address byte) {
+	e.address = address % eepromWords
+	e.shift, e.bits = 0, 0
+	e.state = eepromIdle
+	switch opcode {
+	case eepromRead:
+		// A dummy 0 comes out before the data
+		e.do = false
+		e.shift = e.data[e.address]
+		e.state = eepromReading
+	case eepromWrite:
+		e.writeAll = false
+		e.state = eepromWriting
+	case eepromErase:
+		if e.writeEnabled {
+			e.data[e.address] = 0xFFFF
+		}
+	case eepromExtended:
+		switch address >> 6 {
+		case eepromDisableWrites:
+			e.writeEnabled = false
+		case eepromEnableWrites:
+			e.writeEnabled = true
+		case eepromEraseAll:
+			if e.writeEnabled {
+				for i := range e.data {
+					e.data[i] = 0xFFFF
+				}
+			}
+		case eepromWriteAll:
+			e.writeAll = true
+			e.state = eepromWriting
+		}
+	}
+}
+
+func (e *eeprom) store(value uint16) {
+	if !e.writeEnabled {
+		return
+	}
+	if !e.writeAll {
+		e.data[e.address] = value
+		return
+	}
+	for i := range e.data {
+		e.data[i] = value
+	}
+}
+
+func newEEPROM() *eeprom {
+	e := &eeprom{}
+	for i := range e.data {
+		e.data[i] = 0xFFFF
+	}
+	return e
+}
+
+// bytes returns the contents with each word little endian
+func (e *eeprom) bytes() []byte {
+	data := make([]byte, 2*eepromWords)
+	for i, word := range e.data {
+		data[2*i] = byte(word)
+		data[2*i+1] = byte(word >> 8)
+	}
+	return data
+}
+
+func (e *eeprom) load(data []byte) {
+	for i := range e.data {
+		if 2*i+1 >= len(data) {
+			return
+		}
+		e.data[i] = uint16(data[2*i]) | uint16(data[2*i+1])<<8
+	}
+}
@@ -0,0 +1,270 @@
+package cartridge
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	LogoAddress             = 0x104
+	TitleAddress            = 0x134
+	ManufacturerAddress     = 0x13F
+	CGBFlagAddress          = 0x143
+	NewLicenseeAddress      = 0x144
+	SGBFlagAddress          = 0x146
+	CartridgeTypeAddress    = 0x147
+	ROMSizeAddress          = 0x148
+	RAMSizeAddress          = 0x149
+	DestinationAddress      = 0x14A
+	OldLicenseeAddress      = 0x14B
+	VersionAddress          = 0x14C
+	HeaderChecksumAddress   = 0x14D
+	GlobalChecksumAddress   = 0x14E
+	HeaderEnd               = 0x150
+	TitleLength             = 16
+	ManufacturerTitleLength = 11
+	ManufacturerCodeLength  = 4
+	ROMBankSize             = 0x4000
+	MinROMSize              = 2 * ROMBankSize
+	MulticartROMSize        = 0x100000
+	MulticartGameSize       = 0x40000
+)
+
+const (
+	CGBSupported        = 0x80
+	CGBOnly             = 0xC0
+	SGBSupported        = 0x03
+	UseNewLicensee      = 0x33
+	DestinationJapanese = 0x00
+)
+
+var (
+	ErrUnsupportedMapper = errors.New("unsupported cartridge hardware")
+	ErrTruncatedROM      = errors.New("truncated ROM")
+	ErrHeaderMismatch    = errors.New("ROM does not match its header")
+)
+
+// The boot ROM compares this against the cartridge and locks up on a mismatch
+var nintendoLogo = [...]byte{
+	0xCE, 0xED, 0x66, 0x66, 0xCC, 0x0D, 0x00, 0x0B, 0x03, 0x73, 0x00, 0x83, 0x00, 0x0C, 0x00, 0x0D,
+	0x00, 0x08, 0x11, 0x1F, 0x88, 0x89, 0x00, 0x0E, 0xDC, 0xCC, 0x6E, 0xE6, 0xDD, 0xDD, 0xD9, 0x99,
+	0xBB, 0xBB, 0x67, 0x63, 0x6E, 0x0E, 0xEC, 0xCC, 0xDD, 0xDC, 0x99, 0x9F, 0xBB, 0xB9, 0x33, 0x3E,
+}
+
+// Header holds the cartridge header at 0x100-0x14F along with what was
+// computed from the ROM to check it
+type Header struct {
+	Title            string
+	ManufacturerCode string
+	CGBFlag          byte
+	SGBFlag          byte
+	NewLicensee      string
+	OldLicensee      byte
+	CartridgeType    byte
+	Features
+	KnownType      bool
+	Multicart      bool
+	ROMSizeCode    byte
+	RAMSizeCode    byte
+	ROMSize        int
+	RAMSize        int
+	Destination    byte
+	Version        byte
+	HeaderChecksum byte
+	GlobalChecksum uint16
+
+	LogoValid              bool
+	ComputedHeaderChecksum byte
+	ComputedGlobalChecksum uint16
+	FileSize               int
+}
+
+// Parse reads the header of a ROM. It only fails if the ROM is too short to
+// hold a header; use Problems to find inconsistencies.
+func Parse(rom []byte) (*Header, error) {
+	if len(rom) < HeaderEnd {
+		return nil, errors.Wrapf(ErrTruncatedROM, "%d bytes is too short for a cartridge header", len(rom))
+	}
+	h := &Header{
+		CGBFlag:        rom[CGBFlagAddress],
+		SGBFlag:        rom[SGBFlagAddress],
+		NewLicensee:    string(rom[NewLicenseeAddress : NewLicenseeAddress+2]),
+		OldLicensee:    rom[OldLicenseeAddress],
+		CartridgeType:  rom[CartridgeTypeAddress],
+		ROMSizeCode:    rom[ROMSizeAddress],
+		RAMSizeCode:    rom[RAMSizeAddress],
+		Destination:    rom[DestinationAddress],
+		Version:        rom[VersionAddress],
+		HeaderChecksum: rom[HeaderChecksumAddress],
+		GlobalChecksum: uint16(rom[GlobalChecksumAddress])<<8 | uint16(rom[GlobalChecksumAddress+1]),
+		LogoValid:      bytes.Equal(rom[LogoAddress:LogoAddress+len(nintendoLogo)], nintendoLogo[:]),
+		FileSize:       len(rom),
+	}
+
+	// Colour-era headers give up the last title byte for the CGB flag, and
+	// later ones four more for a manufacturer code
+	manufacturer := rom[ManufacturerAddress : ManufacturerAddress+ManufacturerCodeLength]
+	switch {
+	case h.SupportsCGB() && isManufacturerCode(manufacturer):
+		h.Title = parseText(rom[TitleAddress : TitleAddress+ManufacturerTitleLength])
+		h.ManufacturerCode = string(manufacturer)
+	case h.SupportsCGB():
+		h.Title = parseText(rom[TitleAddress:CGBFlagAddress])
+	default:
+		h.Title = parseText(rom[TitleAddress : TitleAddress+TitleLength])
+	}
+
+	h.Features, h.KnownType = cartridgeTypes[h.CartridgeType]
+	h.Multicart = h.Controller == MBC1 && isMulticart(rom)
+	h.ROMSize = romSize(h.ROMSizeCode)
+	h.RAMSize = ramSize(h.RAMSizeCode)
+
+	for _, value := range rom[TitleAddress:HeaderChecksumAddress] {
+		h.ComputedHeaderChecksum = h.ComputedHeaderChecksum - value - 1
+	}
+	for i, value := range rom {
+		if i != GlobalChecksumAddress && i != GlobalChecksumAddress+1 {
+			h.ComputedGlobalChecksum += uint16(value)
+		}
+	}
+	return h, nil
+}
+
+// parseText returns the printable text up to the first zero byte
+func parseText(data []byte) string {
+	if end := bytes.IndexByte(data, 0); end >= 0 {
+		data = data[:end]
+	}
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		if r < ' ' || r > '~' {
+			return -1
+		}
+		return r
+	}, string(data)))
+}
+
+// isMulticart reports whether a 1MB ROM holds several games, each at the
+// start of a 256KB block with its own header. The menu's logo is always
+// there, so look for another.
+func isMulticart(rom []byte) bool {
+	if len(rom) != MulticartROMSize {
+		return false
+	}
+	for game := MulticartGameSize; game < len(rom); game += MulticartGameSize {
+		logo := rom[game+LogoAddress : game+LogoAddress+len(nintendoLogo)]
+		if bytes.Equal(logo, nintendoLogo[:]) {
+			return true
+		}
+	}
+	return false
+}
+
+func isManufacturerCode(code []byte) bool {
+	for _, value := range code {
+		if (value < 'A' || value > 'Z') && (value < '0' || value > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// romSize returns the size in bytes for a ROM size code, or 0 if unknown
+func romSize(code byte) int {
+	switch {
+	case code <= 0x8:
+		return MinROMSize << code
+	case code == 0x52:
+		return 72 * ROMBankSize
+	case code == 0x53:
+		return 80 * ROMBankSize
+	case code == 0x54:
+		return 96 * ROMBankSize
+	}
+	return 0
+}
+
+// ramSize returns the size in bytes for a RAM size code, or -1 if unknown
+func ramSize(code byte) int {
+	switch code {
+	case 0x0:
+		return 0
+	case 0x1:
+		return 0x800
+	case 0x2:
+		return 0x2000
+	case 0x3:
+		return 0x8000
+	case 0x4:
+		return 0x20000
+	case 0x5:
+		return 0x10000
+	}
+	return -1
+}
+
+func (h *Header) SupportsCGB() bool {
+	return h.CGBFlag&CGBSupported != 0
+}
+
+func (h *Header) RequiresCGB() bool {
+	return h.CGBFlag == CGBOnly
+}
+
+func (h *Header) SupportsSGB() bool {
+	return h.SGBFlag == SGBSupported
+}
+
+// Licensee returns the publisher code, which newer cartridges store as two
+// characters
+func (h *Header) Licensee() string {
+	if h.OldLicensee == UseNewLicensee {
+		return h.NewLicensee
+	}
+	return fmt.Sprintf("%02X", h.OldLicensee)
+}
+
+// TypeName describes the cartridge type the way Nintendo lists them, such as
+// MBC1+RAM+BATTERY
+func (h *Header) TypeName() string {
+	if !h.KnownType {
+		return fmt.Sprintf("unknown (%02X)", h.CartridgeType)
+	}
+	return h.Features.String()
+}
+
+// Problems lists the ways the header disagrees with itself or with the ROM
+func (h *Header) Problems() []string {
+	var problems []string
+	if !h.LogoValid {
+		problems = append(problems, "Nintendo logo does not match, real hardware would lock up")
+	}
+	if h.HeaderChecksum != h.ComputedHeaderChecksum {
+		problems = append(problems, fmt.Sprintf("header checksum is %02X but should be %02X, real hardware would lock up",
+			h.HeaderChecksum, h.ComputedHeaderChecksum))
+	}
+	if h.GlobalChecksum != h.ComputedGlobalChecksum {
+		problems = append(problems, fmt.Sprintf("global checksum is %04X but should be %04X",
+			h.GlobalChecksum, h.ComputedGlobalChecksum))
+	}
+	if !h.KnownType {
+		problems = append(problems, fmt.Sprintf("unknown cartridge type %02X", h.CartridgeType))
+	}
+	switch {
+	case h.ROMSize == 0:
+		problems = append(problems, fmt.Sprintf("unknown ROM size code %02X", h.ROMSizeCode))
+	case h.FileSize != h.ROMSize:
+		problems = append(problems, fmt.Sprintf("header gives a %d byte ROM but the file is %d bytes", h.ROMSize, h.FileSize))
+	}
+	switch {
+	case h.RAMSize < 0:
+		problems = append(problems, fmt.Sprintf("unknown RAM size code %02X", h.RAMSizeCode))
+	case h.KnownType && !h.RAM && h.RAMSize > 0:
+		problems = append(problems, fmt.Sprintf("%s has no RAM but the header gives %d bytes", h.TypeName(), h.RAMSize))
+	case h.KnownType && h.RAM && h.RAMSize == 0 && h.Controller != MBC7:
+		problems = append(problems, fmt.Sprintf("%s has RAM but the header gives none", h.TypeName()))
+	}
+	return problems
+}
@@ -0,0 +1,159 @@
+package cartridge
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// createROM returns a ROM with a valid logo and checksums
+func createROM(title string, cgbFlag, cartridgeType, romSizeCode, ramSizeCode byte) []byte {
+	rom := make([]byte, romSize(romSizeCode))
+	copy(rom[LogoAddress:], nintendoLogo[:])
+	copy(rom[TitleAddress:], title)
+	rom[CGBFlagAddress] = cgbFlag
+	rom[CartridgeTypeAddress] = cartridgeType
+	rom[ROMSizeAddress] = romSizeCode
+	rom[RAMSizeAddress] = ramSizeCode
+	fixChecksums(rom)
+	return rom
+}
+
+func fixChecksums(rom []byte) {
+	var header byte
+	for _, value := range rom[TitleAddress:HeaderChecksumAddress] {
+		header = header - value - 1
+	}
+	rom[HeaderChecksumAddress] = header
+	var global uint16
+	for i, value := range rom {
+		if i != GlobalChecksumAddress && i != GlobalChecksumAddress+1 {
+			global += uint16(value)
+		}
+	}
+	rom[GlobalChecksumAddress] = byte(global >> 8)
+	rom[GlobalChecksumAddress+1] = byte(global)
+}
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		rom          []byte
+		title        string
+		manufacturer string
+		features     Features
+		romSize      int
+		ramSize      int
+	}{
+		{createROM("TETRIS", 0x00, 0x00, 0x00, 0x00), "TETRIS", "", Features{}, 0x8000, 0},
+		{createROM("SIXTEEN CHARS OK", 'K', 0x03, 0x04, 0x03), "SIXTEEN CHARS OK", "", Features{Controller: MBC1, RAM: true, Battery: true}, 0x80000, 0x8000},
+		{createROM("INSTR_TIMING", 0x80, 0x01, 0x00, 0x00), "INSTR_TIMING", "", Features{Controller: MBC1}, 0x8000, 0},
+		{createROM("POKEMON YELAPSE", 0x80, 0x1B, 0x05, 0x03), "POKEMON YEL", "APSE", Features{Controller: MBC5, RAM: true, Battery: true}, 0x100000, 0x8000},
+		{createROM("GAME", 0xC0, 0x10, 0x06, 0x05), "GAME", "", Features{Controller: MBC3, RTC: true, RAM: true, Battery: true}, 0x200000, 0x10000},
+		{createROM("KIRBY TILT", 0x80, 0x22, 0x06, 0x00), "KIRBY TILT", "", Features{Controller: MBC7, Sensor: true, Rumble: true, RAM: true, Battery: true}, 0x200000, 0},
+		{createROM("GAMEBOYCAMERA", 0x00, 0xFC, 0x05, 0x04), "GAMEBOYCAMERA", "", Features{Controller: PocketCamera, RAM: true, Battery: true}, 0x100000, 0x20000},
+	}
+	for _, test := range testCases {
+		h, err := Parse(test.rom)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Title != test.title {
+			t.Errorf("Expected title %q, got %q", test.title, h.Title)
+		}
+		if h.ManufacturerCode != test.manufacturer {
+			t.Errorf("%s: expected manufacturer %q, got %q", test.title, test.manufacturer, h.ManufacturerCode)
+		}
+		if h.Features != test.features {
+			t.Errorf("%s: expected %v, got %v", test.title, test.features, h.Features)
+		}
+		if h.ROMSize != test.romSize {
+			t.Errorf("%s: expected ROM size %x, got %x", test.title, test.romSize, h.ROMSize)
+		}
+		if h.RAMSize != test.ramSize {
+			t.Errorf("%s: expected RAM size %x, got %x", test.title, test.ramSize, h.RAMSize)
+		}
+		if problems := h.Problems(); len(problems) != 0 {
+			t.Errorf("%s: expected no problems, got %v", test.title, problems)
+		}
+	}
+}
+
+func TestParseTruncated(t *testing.T) {
+	if _, err := Parse(make([]byte, HeaderEnd-1)); errors.Cause(err) != ErrTruncatedROM {
+		t.Errorf("Expected %v, got %v", ErrTruncatedROM, err)
+	}
+}
+
+func TestLicensee(t *testing.T) {
+	rom := createROM("GAME", 0x00, 0x00, 0x00, 0x00)
+	rom[OldLicenseeAddress] = 0x01
+	if h, _ := Parse(rom); h.Licensee() != "01" {
+		t.Errorf("Expected licensee %q, got %q", "01", h.Licensee())
+	}
+	rom[OldLicenseeAddress] = UseNewLicensee
+	copy(rom[NewLicenseeAddress:], "A4")
+	if h, _ := Parse(rom); h.Licensee() != "A4" {
+		t.Errorf("Expected licensee %q, got %q", "A4", h.Licensee())
+	}
+}
+
+func TestTypeName(t *testing.T) {
+	testCases := []struct {
+		cartridgeType byte
+		expected      string
+	}{
+		{0x00, "ROM ONLY"},
+		{0x03, "MBC1+RAM+BATTERY"},
+		{0x09, "ROM+RAM+BATTERY"},
+		{0x10, "MBC3+TIMER+RAM+BATTERY"},
+		{0x1E, "MBC5+RUMBLE+RAM+BATTERY"},
+		{0xFC, "POCKET CAMERA+RAM+BATTERY"},
+		{0x04, "unknown (04)"},
+	}
+	for _, test := range testCases {
+		h, _ := Parse(createROM("", 0x00, test.cartridgeType, 0x00, 0x00))
+		if actual := h.TypeName(); actual != test.expected {
+			t.Errorf("Type %02X: expected %q, got %q", test.cartridgeType, test.expected, actual)
+		}
+	}
+}
+
+func TestProblems(t *testing.T) {
+	testCases := []struct {
+		modify   func(rom []byte) []byte
+		expected string
+	}{
+		{func(rom []byte) []byte { rom[LogoAddress] = 0; return rom }, "logo"},
+		{func(rom []byte) []byte { rom[HeaderChecksumAddress]++; return rom }, "header checksum"},
+		{func(rom []byte) []byte { rom[0x200]++; return rom }, "global checksum"},
+		{func(rom []byte) []byte { rom[CartridgeTypeAddress] = 0x04; return rom }, "unknown cartridge type"},
+		{func(rom []byte) []byte { rom[ROMSizeAddress] = 0x09; return rom }, "unknown ROM size"},
+		{func(rom []byte) []byte { return rom[:0x4000] }, "16384 bytes"},
+		{func(rom []byte) []byte { rom[RAMSizeAddress] = 0x06; return rom }, "unknown RAM size"},
+		{func(rom []byte) []byte { rom[RAMSizeAddress] = 0x02; return rom }, "has no RAM"},
+		{func(rom []byte) []byte { rom[CartridgeTypeAddress] = 0x03; return rom }, "gives none"},
+	}
+	for _, test := range testCases {
+		h, err := Parse(test.modify(createROM("GAME", 0x00, 0x01, 0x00, 0x00)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, problem := range h.Problems() {
+			if strings.Contains(problem, test.expected) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected a problem mentioning %q, got %v", test.expected, h.Problems())
+		}
+	}
+}
+
+func TestMBC2NeedsNoRAMSize(t *testing.T) {
+	h, _ := Parse(createROM("GAME", 0x00, 0x06, 0x00, 0x00))
+	if problems := h.Problems(); len(problems) != 0 {
+		t.Errorf("Expected no problems, got %v", problems)
+	}
+}
@@ -0,0 +1,69 @@
+package cartridge
+
+const huc1InfraredMode = 0x0E
+
+// huc1 is Hudson's MBC1-like controller. Instead of enabling RAM, the
+// register at 0x0000-0x1FFF switches 0xA000-0xBFFF between RAM and an IR
+// port.
+type huc1 struct {
+	rom      []byte
+	ram      []byte
+	romBank  uint
+	ramBank  uint
+	infrared bool
+	battery  bool
+	infraredRegister
+}
+
+func newHuC1(rom []byte, header *Header) (Mapper, error) {
+	return &huc1{rom: rom, ram: newRAM(header), romBank: 1, battery: header.Battery}, nil
+}
+
+func (m *huc1) ReadROM(address uint16) byte {
+	if address < ROMBankSize {
+		return m.rom[address]
+	}
+	return m.rom[romBankAddress(m.rom, m.romBank, address)]
+}
+
+func (m *huc1) WriteControl(address uint16, value byte) {
+	switch {
+	case address < RAMEnableLimit:
+		m.infrared = value&0xF == huc1InfraredMode
+	case address < ROMBankNumberLimit:
+		m.romBank = uint(value & 0x3F)
+	case address < RAMBankNumberLimit:
+		m.ramBank = uint(value & 0x3)
+	}
+}
+
+func (m *huc1) ReadRAM(address uint16) byte {
+	switch {
+	case m.infrared:
+		return m.readInfrared()
+	case len(m.ram) == 0:
+		return 0xFF
+	}
+	return m.ram[ramBankAddress(m.ram, m.ramBank, address)]
+}
+
+func (m *huc1) WriteRAM(address uint16, value byte) {
+	switch {
+	case m.infrared:
+		m.writeInfrared(value)
+	case len(m.ram) > 0:
+		m.ram[ramBankAddress(m.ram, m.ramBank, address)] = value
+	}
+}
+
+func (m *huc1) WriteRAMBank(bank uint, address uint16, value byte) {
+	writeRAMBank(m.ram, bank, address, value)
+}
+
+func (m *huc1) SaveData() []byte {
+	return saveRAM(m.ram, m.battery)
+}
+
+func (m *huc1) LoadSaveData(data []byte) {
+	copy(m.ram, data)
+}
@@ -0,0 +1,75 @@
+package cartridge
+
+import "testing"
+
+func createHuC1() *huc1 {
+	header := &Header{Features: Features{Controller: HuC1, RAM: true, Battery: true}, RAMSize: 0x8000}
+	mapper, _ := newHuC1(createBankedROM(0x100000), header)
+	return mapper.(*huc1)
+}
+
+func TestHuC1Banking(t *testing.T) {
+	testCases := []struct {
+		address  uint16
+		value    byte
+		expected byte
+	}{
+		{0x2000, 0x05, 0x05},
+		{0x3FFF, 0x3F, 0x3F},
+		{0x2000, 0xC2, 0x02},
+		{0x2000, 0x00, 0x00},
+	}
+	m := createHuC1()
+	if actual := m.ReadROM(0x4000); actual != 0x01 {
+		t.Errorf("Expected bank %x at power on, got %x", 0x01, actual)
+	}
+	for _, test := range testCases {
+		m.WriteControl(test.address, test.value)
+		if actual := m.ReadROM(0x4000); actual != test.expected {
+			t.Errorf("Write %x to %x: expected bank %x, got %x", test.value, test.address, test.expected, actual)
+		}
+	}
+
+	for bank := byte(0); bank < 4; bank++ {
+		m.WriteControl(0x4000, bank)
+		m.WriteRAM(0xA000, 0x20+bank)
+	}
+	m.WriteControl(0x4000, 0x01)
+	if actual := m.ReadRAM(0xA000); actual != 0x21 {
+		t.Errorf("Expected RAM bank 1 to read %x, got %x", 0x21, actual)
+	}
+	if data := m.SaveData(); len(data) != 0x8000 || data[0x2000] != 0x21 {
+		t.Errorf("Expected 32KB of save data with %x at %x", 0x21, 0x2000)
+	}
+}
+
+func TestHuC1Infrared(t *testing.T) {
+	a, b := createHuC1(), createHuC1()
+	left, right := NewInfraredLink()
+	a.ConnectInfrared(left)
+	b.ConnectInfrared(right)
+
+	a.WriteControl(0x0000, 0x0A)
+	a.WriteRAM(0xA000, 0x55)
+	a.WriteControl(0x0000, 0x0E)
+	b.WriteControl(0x0000, 0x0E)
+	if actual := b.ReadRAM(0xA000); actual != 0xC0 {
+		t.Errorf("Expected no light and %x, got %x", 0xC0, actual)
+	}
+	a.WriteRAM(0xA000, 0x01)
+	if actual := b.ReadRAM(0xA000); actual != 0xC1 {
+		t.Errorf("Expected light and %x, got %x", 0xC1, actual)
+	}
+	if actual := a.ReadRAM(0xA000); actual != 0xC0 {
+		t.Errorf("Expected the LED not to light its own sensor, got %x", actual)
+	}
+	a.WriteRAM(0xA000, 0x00)
+	if actual := b.ReadRAM(0xA000); actual != 0xC0 {
+		t.Errorf("Expected no light and %x, got %x", 0xC0, actual)
+	}
+
+	a.WriteControl(0x0000, 0x00)
+	if actual := a.ReadRAM(0xA000); actual != 0x55 {
+		t.Errorf("Expected RAM mode to read %x, got %x", 0x55, actual)
+	}
+}
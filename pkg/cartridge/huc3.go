@@ -0,0 +1,191 @@
+package cartridge
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// Values of the HuC3 mode register, which picks what 0xA000-0xBFFF accesses
+const (
+	huc3RAMReadOnly   = 0x0
+	huc3RAM           = 0xA
+	huc3RTCCommand    = 0xB
+	huc3RTCResponse   = 0xC
+	huc3RTCSemaphore  = 0xD
+	huc3InfraredMode  = 0xE
+	huc3RTCMemorySize = 0x100
+)
+
+// HuC3 RTC commands, written to the upper nibble in command mode
+const (
+	huc3ReadMemory   = 0x1
+	huc3WriteMemory  = 0x3
+	huc3AddressLow   = 0x4
+	huc3AddressHigh  = 0x5
+	huc3Extended     = 0x6
+	huc3MinutesOfDay = 24 * 60
+)
+
+// Arguments to the extended command
+const (
+	huc3LatchTime = 0x0
+	huc3SetTime   = 0x1
+	huc3Status    = 0x2
+)
+
+// huc3 is Hudson's controller with an RTC and an IR port. The RTC is a small
+// processor with 256 nibbles of memory that the game talks to by writing a
+// command, then clearing the semaphore to run it. Minutes of the day and
+// days are copied to and from the first six nibbles.
+type huc3 struct {
+	rom     []byte
+	ram     []byte
+	romBank uint
+	ramBank uint
+	mode    byte
+	battery bool
+	infraredRegister
+
+	command  byte
+	argument byte
+	response byte
+	address  byte
+	memory   [huc3RTCMemorySize]byte
+	// start is when the clock read zero minutes and zero days
+	start time.Time
+	now   func() time.Time
+}
+
+func newHuC3(rom []byte, header *Header) (Mapper, error) {
+	m := &huc3{rom: rom, ram: newRAM(header), romBank: 1, battery: header.Battery, now: time.Now}
+	m.start = m.now()
+	return m, nil
+}
+
+func (m *huc3) ReadROM(address uint16) byte {
+	if address < ROMBankSize {
+		return m.rom[address]
+	}
+	return m.rom[romBankAddress(m.rom, m.romBank, address)]
+}
+
+func (m *huc3) WriteControl(address uint16, value byte) {
+	switch {
+	case address < RAMEnableLimit:
+		m.mode = value & 0xF
+	case address < ROMBankNumberLimit:
+		m.romBank = uint(value & 0x7F)
+	case address < RAMBankNumberLimit:
+		m.ramBank = uint(value & 0x3)
+	}
+}
+
+func (m *huc3) ReadRAM(address uint16) byte {
+	switch m.mode {
+	case huc3RAMReadOnly, huc3RAM:
+		if len(m.ram) == 0 {
+			return 0xFF
+		}
+		return m.ram[ramBankAddress(m.ram, m.ramBank, address)]
+	case huc3RTCResponse:
+		return m.command<<4 | m.response
+	case huc3RTCSemaphore:
+		// Commands finish at once so the RTC is always ready
+		return 0xFF
+	case huc3InfraredMode:
+		return m.readInfrared()
+	}
+	return 0xFF
+}
+
+func (m *huc3) WriteRAM(address uint16, value byte) {
+	switch m.mode {
+	case huc3RAM:
+		if len(m.ram) > 0 {
+			m.ram[ramBankAddress(m.ram, m.ramBank, address)] = value
+		}
+	case huc3RTCCommand:
+		m.command = (value >> 4) & 0x7
+		m.argument = value & 0xF
+	case huc3RTCSemaphore:
+		if value&0x1 == 0 {
+			m.runCommand()
+		}
+	case huc3InfraredMode:
+		m.writeInfrared(value)
+	}
+}
+
+func (m *huc3) runCommand() {
+	switch m.command {
+	case huc3ReadMemory:
+		m.response = m.memory[m.address]
+		m.address++
+	case huc3WriteMemory:
+		m.memory[m.address] = m.argument
+		m.address++
+	case huc3AddressLow:
+		m.address = m.address&0xF0 | m.argument
+	case huc3AddressHigh:
+		m.address = m.address&0x0F | m.argument<<4
+	case huc3Extended:
+		switch m.argument {
+		case huc3LatchTime:
+			m.latchTime()
+		case huc3SetTime:
+			m.setTime()
+		case huc3Status:
+			m.response = 0x1
+		}
+	}
+}
+
+// latchTime copies minutes of the day and days into RTC memory, each as
+// three nibbles with the lowest first
+func (m *huc3) latchTime() {
+	minutes := int(m.now().Sub(m.start) / time.Minute)
+	putNibbles(m.memory[0:3], minutes%huc3MinutesOfDay)
+	putNibbles(m.memory[3:6], minutes/huc3MinutesOfDay)
+}
+
+func (m *huc3) setTime() {
+	minutes := getNibbles(m.memory[0:3]) + getNibbles(m.memory[3:6])*huc3MinutesOfDay
+	m.start = m.now().Add(-time.Duration(minutes) * time.Minute)
+}
+
+func putNibbles(nibbles []byte, value int) {
+	for i := range nibbles {
+		nibbles[i] = byte(value>>(4*uint(i))) & 0xF
+	}
+}
+
+func getNibbles(nibbles []byte) int {
+	value := 0
+	for i, nibble := range nibbles {
+		value |= int(nibble&0xF) << (4 * uint(i))
+	}
+	return value
+}
+
+func (m *huc3) WriteRAMBank(bank uint, address uint16, value byte) {
+	writeRAMBank(m.ram, bank, address, value)
+}
+
+// SaveData returns cartridge RAM followed by when the clock was at zero, in
+// Unix seconds, so that it keeps time while the emulator is off
+func (m *huc3) SaveData() []byte {
+	data := saveRAM(m.ram, m.battery)
+	if data == nil {
+		return nil
+	}
+	var start [8]byte
+	binary.LittleEndian.PutUint64(start[:], uint64(m.start.Unix()))
+	return append(data, start[:]...)
+}
+
+func (m *huc3) LoadSaveData(data []byte) {
+	copy(m.ram, data)
+	if len(data) >= len(m.ram)+8 {
+		m.start = time.Unix(int64(binary.LittleEndian.Uint64(data[len(m.ram):])), 0)
+	}
+}
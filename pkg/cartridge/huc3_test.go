@@ -0,0 +1,120 @@
+package cartridge
+
+import (
+	"testing"
+	"time"
+)
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func createHuC3(clock *testClock) *huc3 {
+	header := &Header{Features: Features{Controller: HuC3, RTC: true, RAM: true, Battery: true}, RAMSize: 0x2000}
+	mapper, _ := newHuC3(createBankedROM(0x100000), header)
+	m := mapper.(*huc3)
+	m.now = clock.Now
+	m.start = clock.now
+	return m
+}
+
+// rtcCommand runs an RTC command the way games do and returns the response
+func rtcCommand(m *huc3, command, argument byte) byte {
+	m.WriteControl(0x0000, huc3RTCCommand)
+	m.WriteRAM(0xA000, command<<4|argument)
+	m.WriteControl(0x0000, huc3RTCSemaphore)
+	m.WriteRAM(0xA000, 0xFE)
+	m.WriteControl(0x0000, huc3RTCResponse)
+	return m.ReadRAM(0xA000)
+}
+
+// readTime latches the time and reads back minutes of the day and days
+func readTime(m *huc3) (int, int) {
+	rtcCommand(m, huc3Extended, huc3LatchTime)
+	rtcCommand(m, huc3AddressLow, 0x0)
+	rtcCommand(m, huc3AddressHigh, 0x0)
+	var nibbles [6]byte
+	for i := range nibbles {
+		nibbles[i] = rtcCommand(m, huc3ReadMemory, 0) & 0xF
+	}
+	return getNibbles(nibbles[0:3]), getNibbles(nibbles[3:6])
+}
+
+func TestHuC3RTC(t *testing.T) {
+	clock := &testClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	m := createHuC3(clock)
+
+	// Set the clock to day 0x123, 10:30
+	rtcCommand(m, huc3AddressLow, 0x0)
+	rtcCommand(m, huc3AddressHigh, 0x0)
+	for _, nibble := range []byte{0x6, 0x7, 0x2, 0x3, 0x2, 0x1} {
+		rtcCommand(m, huc3WriteMemory, nibble)
+	}
+	rtcCommand(m, huc3Extended, huc3SetTime)
+	if minutes, days := readTime(m); minutes != 630 || days != 0x123 {
+		t.Errorf("Expected 630 minutes and day %x, got %d minutes and day %x", 0x123, minutes, days)
+	}
+
+	clock.now = clock.now.Add(14*time.Hour + 5*time.Minute)
+	if minutes, days := readTime(m); minutes != 35 || days != 0x124 {
+		t.Errorf("Expected 35 minutes and day %x, got %d minutes and day %x", 0x124, minutes, days)
+	}
+
+	if actual := rtcCommand(m, huc3Extended, huc3Status); actual != huc3Extended<<4|0x1 {
+		t.Errorf("Expected status %x, got %x", huc3Extended<<4|0x1, actual)
+	}
+	m.WriteControl(0x0000, huc3RTCSemaphore)
+	if actual := m.ReadRAM(0xA000) & 0x1; actual != 0x1 {
+		t.Errorf("Expected the RTC to be ready")
+	}
+}
+
+func TestHuC3SaveKeepsTime(t *testing.T) {
+	clock := &testClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	m := createHuC3(clock)
+	m.WriteControl(0x0000, huc3RAM)
+	m.WriteRAM(0xA000, 0x42)
+	clock.now = clock.now.Add(2 * time.Hour)
+	data := m.SaveData()
+	if len(data) != 0x2000+8 {
+		t.Fatalf("Expected %d bytes of save data, got %d", 0x2000+8, len(data))
+	}
+
+	clock.now = clock.now.Add(48 * time.Hour)
+	loaded := createHuC3(clock)
+	loaded.LoadSaveData(data)
+	loaded.WriteControl(0x0000, huc3RAMReadOnly)
+	if actual := loaded.ReadRAM(0xA000); actual != 0x42 {
+		t.Errorf("Expected %x, got %x", 0x42, actual)
+	}
+	loaded.WriteRAM(0xA000, 0x11)
+	if actual := loaded.ReadRAM(0xA000); actual != 0x42 {
+		t.Errorf("Expected read-only RAM to keep %x, got %x", 0x42, actual)
+	}
+	if minutes, days := readTime(loaded); minutes != 120 || days != 2 {
+		t.Errorf("Expected 120 minutes and day 2, got %d minutes and day %d", minutes, days)
+	}
+}
+
+func TestHuC3Infrared(t *testing.T) {
+	clock := &testClock{now: time.Now()}
+	a, b := createHuC3(clock), createHuC3(clock)
+	left, right := NewInfraredLink()
+	a.ConnectInfrared(left)
+	b.ConnectInfrared(right)
+	a.WriteControl(0x0000, huc3InfraredMode)
+	b.WriteControl(0x0000, huc3InfraredMode)
+
+	b.WriteRAM(0xA000, 0x01)
+	if actual := a.ReadRAM(0xA000); actual != 0xC1 {
+		t.Errorf("Expected light and %x, got %x", 0xC1, actual)
+	}
+	b.WriteRAM(0xA000, 0x00)
+	if actual := a.ReadRAM(0xA000); actual != 0xC0 {
+		t.Errorf("Expected no light and %x, got %x", 0xC0, actual)
+	}
+}
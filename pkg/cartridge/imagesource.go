@@ -0,0 +1,100 @@
+package cartridge
+
+import (
+	"image"
+	// Register the formats image.Decode reads
+	_ "image/jpeg"
+	_ "image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ImageSource supplies what the Pocket Camera sees. The picture is cropped
+// around its centre to 128x112 and shrunk to fit.
+type ImageSource interface {
+	// Frame returns the picture for the next capture
+	Frame() image.Image
+}
+
+// OpenImageSource shows the camera a PNG or JPEG file, or if path is a
+// directory, each of the pictures in it in name order, one per capture
+func OpenImageSource(path string) (ImageSource, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't open camera image")
+	}
+	if !info.IsDir() {
+		return NewImageFile(path)
+	}
+	return NewImageDirectory(path)
+}
+
+// NewImageFile shows the camera a still PNG or JPEG file
+func NewImageFile(path string) (ImageSource, error) {
+	picture, err := decodeImage(path)
+	if err != nil {
+		return nil, err
+	}
+	return &imageFrames{frames: []image.Image{picture}}, nil
+}
+
+// NewImageDirectory shows the camera the PNG and JPEG files in a directory in
+// name order, moving to the next on each capture and looping at the end
+func NewImageDirectory(path string) (ImageSource, error) {
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't read camera frames")
+	}
+	var names []string
+	for _, file := range files {
+		switch strings.ToLower(filepath.Ext(file.Name())) {
+		case ".png", ".jpg", ".jpeg":
+			names = append(names, file.Name())
+		}
+	}
+	if len(names) == 0 {
+		return nil, errors.Errorf("no PNG or JPEG frames in %s", path)
+	}
+	sort.Strings(names)
+
+	source := &imageFrames{}
+	for _, name := range names {
+		picture, err := decodeImage(filepath.Join(path, name))
+		if err != nil {
+			return nil, err
+		}
+		source.frames = append(source.frames, picture)
+	}
+	return source, nil
+}
+
+// decodeImage reads a picture and shrinks it to the sensor straight away, so
+// a long run of frames doesn't hold every full-size picture
+func decodeImage(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't open camera image")
+	}
+	defer file.Close()
+	picture, _, err := image.Decode(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't decode %s", path)
+	}
+	return newSensorImage(picture).gray(), nil
+}
+
+type imageFrames struct {
+	frames []image.Image
+	next   int
+}
+
+func (s *imageFrames) Frame() image.Image {
+	frame := s.frames[s.next]
+	s.next = (s.next + 1) % len(s.frames)
+	return frame
+}
@@ -0,0 +1,89 @@
+package cartridge
+
+import (
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeImage(t *testing.T, path string, picture image.Image) {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if filepath.Ext(path) == ".png" {
+		err = png.Encode(file, picture)
+	} else {
+		err = jpeg.Encode(file, picture, &jpeg.Options{Quality: 100})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImageSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goboy-camera")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A wide picture is cropped to its middle, so the black bars at each
+	// side are cut off
+	wide := image.NewRGBA(image.Rect(0, 0, 400, 112))
+	for x := 0; x < 400; x++ {
+		for y := 0; y < 112; y++ {
+			level := byte(0xFF)
+			if x < 136 || x >= 264 {
+				level = 0
+			}
+			wide.Set(x, y, color.RGBA{R: level, G: level, B: level, A: 0xFF})
+		}
+	}
+	writeImage(t, filepath.Join(dir, "1.png"), wide)
+	writeImage(t, filepath.Join(dir, "2.jpg"), createPicture(0x40, 0x40))
+	ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a frame"), 0644)
+
+	source, err := OpenImageSource(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		x        int
+		expected byte
+	}{
+		{0, 0xFF},
+		{0, 0x40},
+		{127, 0xFF},
+	}
+	// Frames come in name order and loop back to the start
+	for i, test := range testCases {
+		frame := source.Frame()
+		if bounds := frame.Bounds(); bounds.Dx() != SensorWidth || bounds.Dy() != SensorHeight {
+			t.Errorf("Frame %d: expected %dx%d, got %dx%d", i, SensorWidth, SensorHeight, bounds.Dx(), bounds.Dy())
+		}
+		actual := color.GrayModel.Convert(frame.At(test.x, 50)).(color.Gray).Y
+		if diff := int(actual) - int(test.expected); diff < -2 || diff > 2 {
+			t.Errorf("Frame %d: expected %x at x=%d, got %x", i, test.expected, test.x, actual)
+		}
+	}
+
+	still, err := OpenImageSource(filepath.Join(dir, "2.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if still.Frame() != still.Frame() {
+		t.Errorf("Expected a still picture to give the same frame every time")
+	}
+
+	empty, _ := ioutil.TempDir(dir, "empty")
+	if _, err := OpenImageSource(empty); err == nil {
+		t.Errorf("Expected an error for a directory without frames")
+	}
+}
@@ -0,0 +1,68 @@
+package cartridge
+
+import "sync"
+
+// Infrared is an IR transceiver. The cartridge turns its LED on and off and
+// checks whether the sensor sees light from the other end.
+type Infrared interface {
+	SetLED(on bool)
+	Light() bool
+}
+
+// InfraredPort is a mapper with an IR port
+type InfraredPort interface {
+	ConnectInfrared(ir Infrared)
+}
+
+// NewInfraredLink returns two transceivers facing each other, so that two
+// emulators can talk over IR. Each end may be used from its own goroutine.
+func NewInfraredLink() (Infrared, Infrared) {
+	link := &infraredLink{}
+	return &infraredEnd{link: link, side: 0}, &infraredEnd{link: link, side: 1}
+}
+
+type infraredLink struct {
+	sync.Mutex
+	led [2]bool
+}
+
+type infraredEnd struct {
+	link *infraredLink
+	side int
+}
+
+func (e *infraredEnd) SetLED(on bool) {
+	e.link.Lock()
+	e.link.led[e.side] = on
+	e.link.Unlock()
+}
+
+func (e *infraredEnd) Light() bool {
+	e.link.Lock()
+	defer e.link.Unlock()
+	return e.link.led[1-e.side]
+}
+
+// infraredRegister is how HuC1 and HuC3 map their IR port to 0xA000-0xBFFF
+type infraredRegister struct {
+	ir Infrared
+}
+
+func (r *infraredRegister) ConnectInfrared(ir Infrared) {
+	r.ir = ir
+}
+
+// readInfrared returns 0xC1 while the sensor sees light and 0xC0 otherwise
+func (r *infraredRegister) readInfrared() byte {
+	if r.ir != nil && r.ir.Light() {
+		return 0xC1
+	}
+	return 0xC0
+}
+
+// writeInfrared turns the LED on if bit 0 is set
+func (r *infraredRegister) writeInfrared(value byte) {
+	if r.ir != nil {
+		r.ir.SetLED(value&0x1 != 0)
+	}
+}
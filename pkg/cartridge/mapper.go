@@ -0,0 +1,136 @@
+package cartridge
+
+import "github.com/pkg/errors"
+
+const (
+	ROMEnd   = 0x7FFF
+	RAMStart = 0xA000
+	RAMEnd   = 0xBFFF
+
+	RAMBankSize = 0x2000
+)
+
+// Mapper is the controller on a cartridge that maps its ROM and RAM into the
+// address space
+type Mapper interface {
+	// ReadROM reads from 0x0000-0x7FFF
+	ReadROM(address uint16) byte
+	// WriteControl handles writes to 0x0000-0x7FFF, which set the
+	// controller's registers
+	WriteControl(address uint16, value byte)
+	// ReadRAM and WriteRAM access 0xA000-0xBFFF
+	ReadRAM(address uint16) byte
+	WriteRAM(address uint16, value byte)
+	// SaveData returns the battery-backed state, or nil if there is none
+	SaveData() []byte
+	LoadSaveData(data []byte)
+}
+
+// BankedRAM is a mapper with more than one bank of RAM. WriteRAMBank writes
+// to a bank whether or not it is selected or RAM is enabled, which is how
+// cheat devices change values the game keeps in RAM.
+type BankedRAM interface {
+	WriteRAMBank(bank uint, address uint16, value byte)
+}
+
+// MapperFunc returns a mapper for a ROM whose size has been checked against
+// its header
+type MapperFunc func(rom []byte, header *Header) (Mapper, error)
+
+var controllerMappers = map[Controller]MapperFunc{
+	NoController: newROMOnly,
+	MBC1:         newMBC1,
+	MBC2:         newMBC2,
+	MBC7:         newMBC7,
+	PocketCamera: newCamera,
+	HuC1:         newHuC1,
+	HuC3:         newHuC3,
+}
+
+var typeMappers = map[byte]MapperFunc{}
+
+// Register adds a mapper for a cartridge type code, replacing the built-in one
+// if there is one. Homebrew boards can use a code Nintendo never assigned.
+// Register isn't safe to call while ROMs are loading, so call it from init.
+func Register(cartridgeType byte, features Features, newMapper MapperFunc) {
+	cartridgeTypes[cartridgeType] = features
+	typeMappers[cartridgeType] = newMapper
+}
+
+// NewMapper checks the header against the ROM and returns a mapper for it.
+// Errors wrap ErrTruncatedROM, ErrHeaderMismatch or ErrUnsupportedMapper.
+func NewMapper(rom []byte) (Mapper, *Header, error) {
+	header, err := Parse(rom)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !header.KnownType {
+		return nil, nil, errors.Wrapf(ErrHeaderMismatch, "unknown cartridge type %#02x", header.CartridgeType)
+	}
+	newMapper, ok := typeMappers[header.CartridgeType]
+	if !ok {
+		newMapper, ok = controllerMappers[header.Controller]
+	}
+	if !ok {
+		return nil, nil, errors.Wrapf(ErrUnsupportedMapper, "%s", header.TypeName())
+	}
+
+	switch {
+	case header.ROMSize == 0:
+		return nil, nil, errors.Wrapf(ErrHeaderMismatch, "unknown ROM size code %#02x", header.ROMSizeCode)
+	case len(rom) < header.ROMSize:
+		return nil, nil, errors.Wrapf(ErrTruncatedROM, "header gives %d bytes but ROM has %d", header.ROMSize, len(rom))
+	case header.RAMSize < 0:
+		return nil, nil, errors.Wrapf(ErrHeaderMismatch, "unknown RAM size code %#02x", header.RAMSizeCode)
+	}
+	// Overdumps and patched ROMs can be longer than their header says. The
+	// header still lists it in Problems.
+	rom = rom[:header.ROMSize]
+
+	mapper, err := newMapper(rom, header)
+	if err != nil {
+		return nil, nil, err
+	}
+	return mapper, header, nil
+}
+
+// romBankAddress returns the index into the ROM of an address in the
+// switchable bank, wrapping the bank number to the size of the ROM
+func romBankAddress(rom []byte, bank uint, address uint16) uint {
+	return (bank%uint(len(rom)/ROMBankSize))*ROMBankSize + uint(address-ROMBankSize)
+}
+
+// ramBankAddress returns the index into RAM of an address in the switchable
+// bank, mirroring it to the size of the RAM
+func ramBankAddress(ram []byte, bank uint, address uint16) uint {
+	return (bank*RAMBankSize + uint(address-RAMStart)) % uint(len(ram))
+}
+
+// writeRAMBank writes to a bank of RAM, if there is any
+func writeRAMBank(ram []byte, bank uint, address uint16, value byte) {
+	if len(ram) > 0 {
+		ram[ramBankAddress(ram, bank, address)] = value
+	}
+}
+
+// newRAM returns RAM of the size in the header, or nil if the cartridge
+// has none. Some test ROMs declare RAM without a size, so they get a bank.
+func newRAM(header *Header) []byte {
+	switch {
+	case !header.RAM:
+		return nil
+	case header.RAMSize == 0:
+		return make([]byte, RAMBankSize)
+	}
+	return make([]byte, header.RAMSize)
+}
+
+// saveRAM returns a copy of RAM if a battery keeps it while switched off
+func saveRAM(ram []byte, battery bool) []byte {
+	if !battery || len(ram) == 0 {
+		return nil
+	}
+	data := make([]byte, len(ram))
+	copy(data, ram)
+	return data
+}
@@ -0,0 +1,85 @@
+package cartridge
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+type testMapper struct {
+	romOnly
+	writes int
+}
+
+func (m *testMapper) WriteControl(address uint16, value byte) {
+	m.writes++
+}
+
+func TestRegister(t *testing.T) {
+	const homebrewType = 0xE0
+	rom := createROM("HOMEBREW", 0x00, homebrewType, 0x00, 0x00)
+	if _, _, err := NewMapper(rom); errors.Cause(err) != ErrHeaderMismatch {
+		t.Errorf("Expected %v before registering, got %v", ErrHeaderMismatch, err)
+	}
+
+	features, hadFeatures := cartridgeTypes[homebrewType]
+	newMapper, hadMapper := typeMappers[homebrewType]
+	defer func() {
+		delete(cartridgeTypes, homebrewType)
+		delete(typeMappers, homebrewType)
+		if hadFeatures {
+			cartridgeTypes[homebrewType] = features
+		}
+		if hadMapper {
+			typeMappers[homebrewType] = newMapper
+		}
+	}()
+	Register(homebrewType, Features{Controller: NoController}, func(rom []byte, header *Header) (Mapper, error) {
+		return &testMapper{romOnly: romOnly{rom: rom}}, nil
+	})
+	mapper, header, err := NewMapper(rom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := header.Problems(); len(problems) != 0 {
+		t.Errorf("Expected no problems, got %v", problems)
+	}
+	mapper.WriteControl(0x2000, 0x01)
+	if writes := mapper.(*testMapper).writes; writes != 1 {
+		t.Errorf("Expected the registered mapper to get %d writes, got %d", 1, writes)
+	}
+}
+
+func TestNewMapper(t *testing.T) {
+	testCases := []struct {
+		cartridgeType byte
+		expected      error
+	}{
+		{0x00, nil},
+		{0x01, nil},
+		{0x05, nil},
+		{0x09, nil},
+		{0x13, ErrUnsupportedMapper},
+		{0x04, ErrHeaderMismatch},
+	}
+	for _, test := range testCases {
+		_, _, err := NewMapper(createROM("", 0x00, test.cartridgeType, 0x00, 0x00))
+		if actual := errors.Cause(err); actual != test.expected {
+			t.Errorf("Type %02X: expected %v, got %v", test.cartridgeType, test.expected, actual)
+		}
+	}
+}
+
+func TestROMOnlyWithRAM(t *testing.T) {
+	mapper, _, err := NewMapper(createROM("", 0x00, 0x09, 0x00, 0x02))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapper.WriteRAM(0xA123, 0x42)
+	if actual := mapper.ReadRAM(0xA123); actual != 0x42 {
+		t.Errorf("Expected %x, got %x", 0x42, actual)
+	}
+	if actual := mapper.SaveData(); len(actual) != 0x2000 || actual[0x123] != 0x42 {
+		t.Errorf("Expected 8KB of save data with the write")
+	}
+}
@@ -0,0 +1,104 @@
+package cartridge
+
+const (
+	RAMEnableLimit     = 0x2000
+	ROMBankNumberLimit = 0x4000
+	RAMBankNumberLimit = 0x6000
+)
+
+type bankingMode byte
+
+const (
+	romBanking bankingMode = 0x0
+	ramBanking bankingMode = 0x1
+)
+
+// mbc1 maps up to 2MB of ROM and 32KB of RAM. Its 5-bit register picks the
+// ROM bank at 0x4000 and its 2-bit register adds the bank's upper bits. In
+// mode 1 the 2-bit register also banks 0x0000-0x3FFF and cartridge RAM.
+// Multicarts wire only 4 bits of the 5-bit register, so the 2-bit register
+// picks one of four 256KB games.
+type mbc1 struct {
+	rom        []byte
+	ram        []byte
+	bank1      byte
+	bank2      byte
+	mode       bankingMode
+	ramEnabled bool
+	battery    bool
+	bank2Shift uint
+}
+
+func newMBC1(rom []byte, header *Header) (Mapper, error) {
+	m := &mbc1{rom: rom, ram: newRAM(header), bank1: 1, battery: header.Battery, bank2Shift: 5}
+	if header.Multicart {
+		m.bank2Shift = 4
+	}
+	return m, nil
+}
+
+// romBank returns the ROM bank mapped at an address
+func (m *mbc1) romBank(address uint16) uint {
+	upper := uint(m.bank2) << m.bank2Shift
+	if address < ROMBankSize {
+		if m.mode == romBanking {
+			return 0
+		}
+		return upper
+	}
+	return upper | uint(m.bank1)&(1<<m.bank2Shift-1)
+}
+
+func (m *mbc1) ramBank() uint {
+	if m.mode == romBanking {
+		return 0
+	}
+	return uint(m.bank2)
+}
+
+func (m *mbc1) ReadROM(address uint16) byte {
+	bank := m.romBank(address) % uint(len(m.rom)/ROMBankSize)
+	return m.rom[bank*ROMBankSize+uint(address%ROMBankSize)]
+}
+
+func (m *mbc1) WriteControl(address uint16, value byte) {
+	switch {
+	case address < RAMEnableLimit:
+		m.ramEnabled = (value & 0xF) == 0xA
+	case address < ROMBankNumberLimit:
+		m.bank1 = value & 0x1F
+		if m.bank1 == 0 {
+			m.bank1 = 1
+		}
+	case address < RAMBankNumberLimit:
+		m.bank2 = value & 0x3
+	default:
+		m.mode = bankingMode(value & 0x1)
+	}
+}
+
+func (m *mbc1) ReadRAM(address uint16) byte {
+	if !m.ramEnabled || len(m.ram) == 0 {
+		return 0xFF
+	}
+	return m.ram[ramBankAddress(m.ram, m.ramBank(), address)]
+}
+
+func (m *mbc1) WriteRAM(address uint16, value byte) {
+	if !m.ramEnabled || len(m.ram) == 0 {
+		return
+	}
+	m.ram[ramBankAddress(m.ram, m.ramBank(), address)] = value
+}
+
+func (m *mbc1) WriteRAMBank(bank uint, address uint16, value byte) {
+	writeRAMBank(m.ram, bank, address, value)
+}
+
+func (m *mbc1) SaveData() []byte {
+	return saveRAM(m.ram, m.battery)
+}
+
+func (m *mbc1) LoadSaveData(data []byte) {
+	copy(m.ram, data)
+}
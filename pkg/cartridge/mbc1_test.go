@@ -0,0 +1,149 @@
+package cartridge
+
+import "testing"
+
+// createBankedROM returns a ROM with each bank's number in its first byte
+func createBankedROM(size int) []byte {
+	rom := make([]byte, size)
+	for bank := 0; bank < size/ROMBankSize; bank++ {
+		rom[bank*ROMBankSize] = byte(bank)
+	}
+	return rom
+}
+
+func createMBC1(romSize, ramSize int, multicart bool) *mbc1 {
+	header := &Header{Features: Features{Controller: MBC1, RAM: ramSize > 0}, RAMSize: ramSize, Multicart: multicart}
+	mapper, _ := newMBC1(createBankedROM(romSize), header)
+	return mapper.(*mbc1)
+}
+
+type mbc1Write struct {
+	address uint16
+	value   byte
+}
+
+func TestMBC1RAMEnable(t *testing.T) {
+	testCases := []struct {
+		address        uint16
+		input          byte
+		enableExpected bool
+	}{
+		{address: 0x0000, input: 0xAA, enableExpected: true},
+		{address: 0x1000, input: 0x0A, enableExpected: true},
+		{address: 0x1FFF, input: 0x12, enableExpected: false},
+		{address: 0x0FFF, input: 0xA0, enableExpected: false},
+		{address: 0x1234, input: 0x1A, enableExpected: true},
+	}
+	m := createMBC1(0x8000, 0x2000, false)
+	for _, test := range testCases {
+		m.WriteControl(test.address, test.input)
+		if actual := m.ramEnabled; actual != test.enableExpected {
+			t.Errorf("Write %x to %x: RAM enable state is %t, expected %t", test.input, test.address, actual, test.enableExpected)
+		}
+	}
+}
+
+func TestMBC1ROMBanks(t *testing.T) {
+	testCases := []struct {
+		romSize      int
+		writes       []mbc1Write
+		lower, upper byte
+		description  string
+	}{
+		{0x200000, nil, 0x00, 0x01, "power on"},
+		{0x200000, []mbc1Write{{0x2000, 0x00}}, 0x00, 0x01, "bank 0 maps bank 1"},
+		{0x200000, []mbc1Write{{0x2000, 0x12}}, 0x00, 0x12, "5-bit register"},
+		{0x200000, []mbc1Write{{0x3FFF, 0xE3}}, 0x00, 0x03, "upper bits ignored"},
+		{0x200000, []mbc1Write{{0x2000, 0x20}}, 0x00, 0x01, "zero check uses masked value"},
+		{0x200000, []mbc1Write{{0x2000, 0x01}, {0x4000, 0x02}}, 0x00, 0x41, "2-bit register"},
+		{0x200000, []mbc1Write{{0x2000, 0x00}, {0x5FFF, 0x03}}, 0x00, 0x61, "bank 0x60 maps bank 0x61"},
+		{0x200000, []mbc1Write{{0x4000, 0xFE}, {0x2000, 0x05}}, 0x00, 0x45, "2-bit register ignores upper bits"},
+		{0x200000, []mbc1Write{{0x4000, 0x02}, {0x6000, 0x01}}, 0x40, 0x41, "mode 1 banks 0x0000"},
+		{0x200000, []mbc1Write{{0x4000, 0x02}, {0x6000, 0xFF}, {0x6000, 0xFE}}, 0x00, 0x41, "mode uses bit 0 only"},
+		{0x100000, []mbc1Write{{0x4000, 0x01}, {0x6000, 0x01}}, 0x20, 0x21, "1MB mode 1"},
+		{0x100000, []mbc1Write{{0x4000, 0x03}, {0x2000, 0x04}}, 0x00, 0x24, "1MB wraps bank 0x64"},
+		{0x80000, []mbc1Write{{0x4000, 0x01}, {0x6000, 0x01}}, 0x00, 0x01, "512KB ignores 2-bit register"},
+		{0x40000, []mbc1Write{{0x2000, 0x1F}}, 0x00, 0x0F, "256KB wraps bank 0x1F"},
+		{0x8000, []mbc1Write{{0x2000, 0x02}}, 0x00, 0x00, "32KB wraps bank 2 to bank 0"},
+	}
+	for _, test := range testCases {
+		m := createMBC1(test.romSize, 0, false)
+		for _, write := range test.writes {
+			m.WriteControl(write.address, write.value)
+		}
+		if actual := m.ReadROM(0x0000); actual != test.lower {
+			t.Errorf("%s: expected bank %x at 0x0000, got %x", test.description, test.lower, actual)
+		}
+		if actual := m.ReadROM(0x4000); actual != test.upper {
+			t.Errorf("%s: expected bank %x at 0x4000, got %x", test.description, test.upper, actual)
+		}
+	}
+}
+
+func TestMBC1RAMBanks(t *testing.T) {
+	m := createMBC1(0x8000, 0x8000, false)
+	m.WriteControl(0x0000, 0x0A)
+	for bank := byte(0); bank < 4; bank++ {
+		m.WriteControl(0x6000, 0x01)
+		m.WriteControl(0x4000, bank)
+		m.WriteRAM(0xA000, 0x10+bank)
+	}
+
+	m.WriteControl(0x6000, 0x00)
+	if actual := m.ReadRAM(0xA000); actual != 0x10 {
+		t.Errorf("Expected mode 0 to use RAM bank 0 and read %x, got %x", 0x10, actual)
+	}
+	m.WriteControl(0x6000, 0x01)
+	for bank := byte(0); bank < 4; bank++ {
+		m.WriteControl(0x4000, bank)
+		if actual := m.ReadRAM(0xA000); actual != 0x10+bank {
+			t.Errorf("Expected RAM bank %d to read %x, got %x", bank, 0x10+bank, actual)
+		}
+	}
+
+	m.WriteControl(0x0000, 0x00)
+	if actual := m.ReadRAM(0xA000); actual != 0xFF {
+		t.Errorf("Expected disabled RAM to read %x, got %x", 0xFF, actual)
+	}
+}
+
+func TestMBC1Multicart(t *testing.T) {
+	testCases := []struct {
+		writes       []mbc1Write
+		lower, upper byte
+		description  string
+	}{
+		{nil, 0x00, 0x01, "power on"},
+		{[]mbc1Write{{0x2000, 0x0F}}, 0x00, 0x0F, "4-bit register"},
+		{[]mbc1Write{{0x2000, 0x10}}, 0x00, 0x00, "bit 4 is not wired"},
+		{[]mbc1Write{{0x2000, 0x03}, {0x4000, 0x02}}, 0x00, 0x23, "2-bit register picks a game"},
+		{[]mbc1Write{{0x4000, 0x03}, {0x6000, 0x01}}, 0x30, 0x31, "mode 1 maps the game's first bank"},
+	}
+	for _, test := range testCases {
+		m := createMBC1(MulticartROMSize, 0, true)
+		for _, write := range test.writes {
+			m.WriteControl(write.address, write.value)
+		}
+		if actual := m.ReadROM(0x0000); actual != test.lower {
+			t.Errorf("%s: expected bank %x at 0x0000, got %x", test.description, test.lower, actual)
+		}
+		if actual := m.ReadROM(0x4000); actual != test.upper {
+			t.Errorf("%s: expected bank %x at 0x4000, got %x", test.description, test.upper, actual)
+		}
+	}
+}
+
+func TestMulticartDetection(t *testing.T) {
+	rom := createROM("MENU", 0x00, 0x01, 0x05, 0x00)
+	if h, _ := Parse(rom); h.Multicart {
+		t.Errorf("Expected a 1MB ROM with one logo not to be a multicart")
+	}
+	copy(rom[2*MulticartGameSize+LogoAddress:], nintendoLogo[:])
+	if h, _ := Parse(rom); !h.Multicart {
+		t.Errorf("Expected a 1MB ROM with a second logo to be a multicart")
+	}
+	rom[CartridgeTypeAddress] = 0x19
+	if h, _ := Parse(rom); h.Multicart {
+		t.Errorf("Expected only MBC1 ROMs to be multicarts")
+	}
+}
@@ -0,0 +1,66 @@
+package cartridge
+
+const MBC2RAMSize = 0x200
+
+// mbc2 maps up to 256KB of ROM and has 512 half-bytes of RAM built in. Bit 8
+// of the address of a write to 0x0000-0x3FFF picks which register it sets.
+type mbc2 struct {
+	rom        []byte
+	ram        []byte
+	romBank    uint
+	ramEnabled bool
+	battery    bool
+}
+
+func newMBC2(rom []byte, header *Header) (Mapper, error) {
+	return &mbc2{rom: rom, ram: make([]byte, MBC2RAMSize), romBank: 1, battery: header.Battery}, nil
+}
+
+func (m *mbc2) ReadROM(address uint16) byte {
+	if address < ROMBankSize {
+		return m.rom[address]
+	}
+	return m.rom[romBankAddress(m.rom, m.romBank, address)]
+}
+
+func (m *mbc2) WriteControl(address uint16, value byte) {
+	if address >= ROMBankNumberLimit {
+		return
+	}
+	if address&0x100 == 0 {
+		m.ramEnabled = (value & 0xF) == 0xA
+		return
+	}
+	bank := uint(value & 0xF)
+	if bank == 0 {
+		bank = 1
+	}
+	m.romBank = bank
+}
+
+// The 512 half-bytes of RAM repeat across 0xA000-0xBFFF and the upper half
+// of each byte reads as 1s
+func (m *mbc2) ReadRAM(address uint16) byte {
+	if !m.ramEnabled {
+		return 0xFF
+	}
+	return 0xF0 | m.ram[address&(MBC2RAMSize-1)]
+}
+
+func (m *mbc2) WriteRAM(address uint16, value byte) {
+	if !m.ramEnabled {
+		return
+	}
+	m.ram[address&(MBC2RAMSize-1)] = value & 0xF
+}
+
+func (m *mbc2) SaveData() []byte {
+	return saveRAM(m.ram, m.battery)
+}
+
+func (m *mbc2) LoadSaveData(data []byte) {
+	copy(m.ram, data)
+	for i := range m.ram {
+		m.ram[i] &= 0xF
+	}
+}
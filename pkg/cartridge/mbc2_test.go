@@ -0,0 +1,100 @@
+package cartridge
+
+import "testing"
+
+func createMBC2(battery bool) *mbc2 {
+	rom := make([]byte, 0x40000)
+	for bank := 0; bank < len(rom)/ROMBankSize; bank++ {
+		rom[bank*ROMBankSize] = byte(bank)
+	}
+	mapper, _ := newMBC2(rom, &Header{Features: Features{Controller: MBC2, Battery: battery}})
+	return mapper.(*mbc2)
+}
+
+func TestMBC2RAM(t *testing.T) {
+	m := createMBC2(true)
+	if actual := m.ReadRAM(0xA000); actual != 0xFF {
+		t.Errorf("Expected disabled RAM to read %x, got %x", 0xFF, actual)
+	}
+	m.WriteRAM(0xA000, 0x05)
+	m.WriteControl(0x0000, 0x0A)
+	if actual := m.ReadRAM(0xA000); actual != 0xF0 {
+		t.Errorf("Expected write to disabled RAM to be ignored and read %x, got %x", 0xF0, actual)
+	}
+
+	testCases := []struct {
+		address, mirror uint16
+		value, expected byte
+	}{
+		{0xA000, 0xA200, 0x05, 0xF5},
+		{0xA1FF, 0xBFFF, 0xAB, 0xFB},
+		{0xA123, 0xB323, 0x3C, 0xFC},
+	}
+	for _, test := range testCases {
+		m.WriteRAM(test.address, test.value)
+		if actual := m.ReadRAM(test.address); actual != test.expected {
+			t.Errorf("%x: expected %x, got %x", test.address, test.expected, actual)
+		}
+		if actual := m.ReadRAM(test.mirror); actual != test.expected {
+			t.Errorf("Mirror %x: expected %x, got %x", test.mirror, test.expected, actual)
+		}
+	}
+
+	m.WriteControl(0x3E00, 0x00)
+	if actual := m.ReadRAM(0xA000); actual != 0xFF {
+		t.Errorf("Expected RAM to be disabled and read %x, got %x", 0xFF, actual)
+	}
+}
+
+func TestMBC2Control(t *testing.T) {
+	testCases := []struct {
+		address      uint16
+		value        byte
+		expectedBank byte
+		ramEnabled   bool
+	}{
+		{0x2100, 0x02, 0x02, false},
+		{0x0100, 0x03, 0x03, false},
+		{0x2100, 0x00, 0x01, false},
+		{0x2100, 0x1F, 0x0F, false},
+		{0x3EFF, 0x0A, 0x0F, true},
+		{0x2000, 0x00, 0x0F, false},
+		{0x4100, 0x02, 0x0F, false},
+	}
+	m := createMBC2(false)
+	for _, test := range testCases {
+		m.WriteControl(test.address, test.value)
+		if actual := m.ReadROM(0x4000); actual != test.expectedBank {
+			t.Errorf("Write %x to %x: expected bank %x, got %x", test.value, test.address, test.expectedBank, actual)
+		}
+		if m.ramEnabled != test.ramEnabled {
+			t.Errorf("Write %x to %x: expected RAM enabled %t, got %t", test.value, test.address, test.ramEnabled, m.ramEnabled)
+		}
+	}
+}
+
+func TestMBC2SaveData(t *testing.T) {
+	m := createMBC2(false)
+	if data := m.SaveData(); data != nil {
+		t.Errorf("Expected no save data without a battery, got %d bytes", len(data))
+	}
+
+	m = createMBC2(true)
+	m.WriteControl(0x0000, 0x0A)
+	m.WriteRAM(0xA010, 0x07)
+	data := m.SaveData()
+	if len(data) != MBC2RAMSize || data[0x10] != 0x07 {
+		t.Fatalf("Expected %d bytes of save data with %x at %x", MBC2RAMSize, 0x07, 0x10)
+	}
+
+	data[0x20] = 0xFE
+	loaded := createMBC2(true)
+	loaded.LoadSaveData(data)
+	loaded.WriteControl(0x0000, 0x0A)
+	if actual := loaded.ReadRAM(0xA010); actual != 0xF7 {
+		t.Errorf("Expected %x, got %x", 0xF7, actual)
+	}
+	if actual := loaded.ReadRAM(0xA020); actual != 0xFE {
+		t.Errorf("Expected %x, got %x", 0xFE, actual)
+	}
+}
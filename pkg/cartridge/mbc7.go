@@ -0,0 +1,130 @@
+package cartridge
+
+const (
+	mbc7RAMEnable2    = 0x40
+	mbc7RegistersEnd  = 0xAFFF
+	mbc7AccelCentre   = 0x81D0
+	mbc7AccelPerG     = 0x70
+	mbc7AccelErased   = 0x8000
+	mbc7EraseLatch    = 0x55
+	mbc7LatchSequence = 0xAA
+)
+
+// Registers at 0xA000-0xAFFF, selected by bits 4-7 of the address
+const (
+	mbc7Erase  = 0x0
+	mbc7Latch  = 0x1
+	mbc7XLow   = 0x2
+	mbc7XHigh  = 0x3
+	mbc7YLow   = 0x4
+	mbc7YHigh  = 0x5
+	mbc7Zero   = 0x6
+	mbc7Serial = 0x8
+)
+
+// Accelerometer is a mapper with a two-axis accelerometer
+type Accelerometer interface {
+	// SetAcceleration sets the force on each axis in g. Positive x tilts
+	// the cartridge right and positive y tilts it down.
+	SetAcceleration(x, y float64)
+}
+
+// mbc7 maps up to 2MB of ROM and, in place of RAM, an accelerometer and a
+// 93LC56 EEPROM. 0xA000-0xAFFF is only mapped with both RAM enables set.
+type mbc7 struct {
+	rom         []byte
+	romBank     uint
+	ramEnabled1 bool
+	ramEnabled2 bool
+	eeprom      *eeprom
+
+	x, y           float64
+	latchX, latchY uint16
+	latchErased    bool
+}
+
+func newMBC7(rom []byte, header *Header) (Mapper, error) {
+	return &mbc7{
+		rom:     rom,
+		romBank: 1,
+		eeprom:  newEEPROM(),
+		latchX:  mbc7AccelErased,
+		latchY:  mbc7AccelErased,
+	}, nil
+}
+
+func (m *mbc7) ReadROM(address uint16) byte {
+	if address < ROMBankSize {
+		return m.rom[address]
+	}
+	return m.rom[romBankAddress(m.rom, m.romBank, address)]
+}
+
+func (m *mbc7) WriteControl(address uint16, value byte) {
+	switch {
+	case address < RAMEnableLimit:
+		m.ramEnabled1 = value&0xF == 0xA
+	case address < ROMBankNumberLimit:
+		m.romBank = uint(value & 0x7F)
+	case address < RAMBankNumberLimit:
+		m.ramEnabled2 = value == mbc7RAMEnable2
+	}
+}
+
+func (m *mbc7) SetAcceleration(x, y float64) {
+	m.x, m.y = x, y
+}
+
+func accelerometerValue(g float64) uint16 {
+	return uint16(mbc7AccelCentre + int(g*mbc7AccelPerG))
+}
+
+func (m *mbc7) ReadRAM(address uint16) byte {
+	if !m.ramEnabled1 || !m.ramEnabled2 || address > mbc7RegistersEnd {
+		return 0xFF
+	}
+	switch (address >> 4) & 0xF {
+	case mbc7XLow:
+		return byte(m.latchX)
+	case mbc7XHigh:
+		return byte(m.latchX >> 8)
+	case mbc7YLow:
+		return byte(m.latchY)
+	case mbc7YHigh:
+		return byte(m.latchY >> 8)
+	case mbc7Zero:
+		return 0x00
+	case mbc7Serial:
+		return m.eeprom.read()
+	}
+	return 0xFF
+}
+
+func (m *mbc7) WriteRAM(address uint16, value byte) {
+	if !m.ramEnabled1 || !m.ramEnabled2 || address > mbc7RegistersEnd {
+		return
+	}
+	switch (address >> 4) & 0xF {
+	case mbc7Erase:
+		if value == mbc7EraseLatch {
+			m.latchErased = true
+			m.latchX, m.latchY = mbc7AccelErased, mbc7AccelErased
+		}
+	case mbc7Latch:
+		// The latch only takes a new reading once it has been erased
+		if value == mbc7LatchSequence && m.latchErased {
+			m.latchErased = false
+			m.latchX, m.latchY = accelerometerValue(m.x), accelerometerValue(m.y)
+		}
+	case mbc7Serial:
+		m.eeprom.write(value)
+	}
+}
+
+func (m *mbc7) SaveData() []byte {
+	return m.eeprom.bytes()
+}
+
+func (m *mbc7) LoadSaveData(data []byte) {
+	m.eeprom.load(data)
+}
@@ -0,0 +1,173 @@
+package cartridge
+
+import "testing"
+
+const eepromAddress = 0xA080
+
+func createMBC7() *mbc7 {
+	header := &Header{Features: Features{Controller: MBC7, Sensor: true, Rumble: true, RAM: true, Battery: true}}
+	mapper, _ := newMBC7(createBankedROM(0x100000), header)
+	m := mapper.(*mbc7)
+	m.WriteControl(0x0000, 0x0A)
+	m.WriteControl(0x4000, 0x40)
+	return m
+}
+
+// clockBit sends one bit to the EEPROM and returns DO after the rising edge
+func clockBit(m *mbc7, bit bool) bool {
+	var di byte
+	if bit {
+		di = 0x02
+	}
+	m.WriteRAM(eepromAddress, 0x80|di)
+	m.WriteRAM(eepromAddress, 0xC0|di)
+	return m.ReadRAM(eepromAddress)&0x01 != 0
+}
+
+// sendEEPROMCommand selects the chip and sends a start bit, opcode, address and
+// any data bits
+func sendEEPROMCommand(m *mbc7, opcode, address byte, data ...uint16) {
+	m.WriteRAM(eepromAddress, 0x00)
+	m.WriteRAM(eepromAddress, 0x80)
+	clockBit(m, true)
+	command := uint16(opcode)<<8 | uint16(address)
+	for i := 9; i >= 0; i-- {
+		clockBit(m, command&(1<<uint(i)) != 0)
+	}
+	for _, word := range data {
+		for i := 15; i >= 0; i-- {
+			clockBit(m, word&(1<<uint(i)) != 0)
+		}
+	}
+}
+
+func eepromReadWord(m *mbc7, address byte) uint16 {
+	sendEEPROMCommand(m, eepromRead, address)
+	var word uint16
+	for i := 0; i < 16; i++ {
+		word <<= 1
+		if clockBit(m, false) {
+			word |= 1
+		}
+	}
+	m.WriteRAM(eepromAddress, 0x00)
+	return word
+}
+
+func TestMBC7EEPROM(t *testing.T) {
+	m := createMBC7()
+	if actual := eepromReadWord(m, 0x05); actual != 0xFFFF {
+		t.Errorf("Expected a blank EEPROM to read %x, got %x", 0xFFFF, actual)
+	}
+
+	sendEEPROMCommand(m, eepromWrite, 0x05, 0x1234)
+	if actual := eepromReadWord(m, 0x05); actual != 0xFFFF {
+		t.Errorf("Expected write before EWEN to be ignored, got %x", actual)
+	}
+
+	sendEEPROMCommand(m, eepromExtended, eepromEnableWrites<<6)
+	sendEEPROMCommand(m, eepromWrite, 0x05, 0x1234)
+	sendEEPROMCommand(m, eepromWrite, 0x06, 0xBEEF)
+	m.WriteRAM(eepromAddress, 0x00)
+	m.WriteRAM(eepromAddress, 0x80)
+	if m.ReadRAM(eepromAddress)&0x01 == 0 {
+		t.Errorf("Expected the EEPROM to report ready after writing")
+	}
+	if actual := eepromReadWord(m, 0x05); actual != 0x1234 {
+		t.Errorf("Expected %x, got %x", 0x1234, actual)
+	}
+	if actual := eepromReadWord(m, 0x85); actual != 0x1234 {
+		t.Errorf("Expected address bit 7 to be ignored and read %x, got %x", 0x1234, actual)
+	}
+
+	// Sequential read carries on into the next word
+	sendEEPROMCommand(m, eepromRead, 0x05)
+	if m.ReadRAM(eepromAddress)&0x01 != 0 {
+		t.Errorf("Expected a dummy 0 before the data")
+	}
+	var words uint32
+	for i := 0; i < 32; i++ {
+		words <<= 1
+		if clockBit(m, false) {
+			words |= 1
+		}
+	}
+	if words != 0x1234BEEF {
+		t.Errorf("Expected sequential read %x, got %x", 0x1234BEEF, words)
+	}
+
+	sendEEPROMCommand(m, eepromErase, 0x05)
+	if actual := eepromReadWord(m, 0x05); actual != 0xFFFF {
+		t.Errorf("Expected erased word %x, got %x", 0xFFFF, actual)
+	}
+
+	sendEEPROMCommand(m, eepromExtended, eepromWriteAll<<6, 0xA5A5)
+	if actual := eepromReadWord(m, 0x7F); actual != 0xA5A5 {
+		t.Errorf("Expected WRAL to write %x, got %x", 0xA5A5, actual)
+	}
+	sendEEPROMCommand(m, eepromExtended, eepromEraseAll<<6)
+	if actual := eepromReadWord(m, 0x00); actual != 0xFFFF {
+		t.Errorf("Expected ERAL to erase to %x, got %x", 0xFFFF, actual)
+	}
+
+	sendEEPROMCommand(m, eepromExtended, eepromDisableWrites<<6)
+	sendEEPROMCommand(m, eepromWrite, 0x00, 0x0000)
+	if actual := eepromReadWord(m, 0x00); actual != 0xFFFF {
+		t.Errorf("Expected EWDS to protect the EEPROM, got %x", actual)
+	}
+}
+
+func TestMBC7SaveData(t *testing.T) {
+	m := createMBC7()
+	sendEEPROMCommand(m, eepromExtended, eepromEnableWrites<<6)
+	sendEEPROMCommand(m, eepromWrite, 0x10, 0xCAFE)
+	data := m.SaveData()
+	if len(data) != 256 || data[0x20] != 0xFE || data[0x21] != 0xCA {
+		t.Fatalf("Expected 256 bytes of save data with %x at %x", 0xCAFE, 0x20)
+	}
+
+	loaded := createMBC7()
+	loaded.LoadSaveData(data)
+	if actual := eepromReadWord(loaded, 0x10); actual != 0xCAFE {
+		t.Errorf("Expected %x, got %x", 0xCAFE, actual)
+	}
+}
+
+func TestMBC7Accelerometer(t *testing.T) {
+	readAxes := func(m *mbc7) (uint16, uint16) {
+		x := uint16(m.ReadRAM(0xA020)) | uint16(m.ReadRAM(0xA030))<<8
+		y := uint16(m.ReadRAM(0xA040)) | uint16(m.ReadRAM(0xA050))<<8
+		return x, y
+	}
+	m := createMBC7()
+	m.SetAcceleration(1, -0.5)
+	if x, y := readAxes(m); x != 0x8000 || y != 0x8000 {
+		t.Errorf("Expected %x before latching, got %x, %x", 0x8000, x, y)
+	}
+
+	m.WriteRAM(0xA010, 0xAA)
+	if x, _ := readAxes(m); x != 0x8000 {
+		t.Errorf("Expected latch without erase to be ignored, got %x", x)
+	}
+
+	m.WriteRAM(0xA000, 0x55)
+	m.WriteRAM(0xA010, 0xAA)
+	if x, y := readAxes(m); x != 0x8240 || y != 0x8198 {
+		t.Errorf("Expected %x, %x, got %x, %x", 0x8240, 0x8198, x, y)
+	}
+
+	m.SetAcceleration(0, 0)
+	if x, _ := readAxes(m); x != 0x8240 {
+		t.Errorf("Expected the latch to hold %x, got %x", 0x8240, x)
+	}
+	m.WriteRAM(0xA000, 0x55)
+	m.WriteRAM(0xA010, 0xAA)
+	if x, y := readAxes(m); x != 0x81D0 || y != 0x81D0 {
+		t.Errorf("Expected %x when level, got %x, %x", 0x81D0, x, y)
+	}
+
+	m.WriteControl(0x4000, 0x00)
+	if actual := m.ReadRAM(0xA020); actual != 0xFF {
+		t.Errorf("Expected registers to need both RAM enables and read %x, got %x", 0xFF, actual)
+	}
+}
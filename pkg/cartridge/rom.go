@@ -0,0 +1,41 @@
+package cartridge
+
+// romOnly is a cartridge with no controller: 32KB of ROM and optionally up to
+// 8KB of RAM, both always mapped in
+type romOnly struct {
+	rom     []byte
+	ram     []byte
+	battery bool
+}
+
+func newROMOnly(rom []byte, header *Header) (Mapper, error) {
+	return &romOnly{rom: rom, ram: newRAM(header), battery: header.Battery}, nil
+}
+
+func (m *romOnly) ReadROM(address uint16) byte {
+	return m.rom[address]
+}
+
+func (m *romOnly) WriteControl(address uint16, value byte) {}
+
+func (m *romOnly) ReadRAM(address uint16) byte {
+	if len(m.ram) == 0 {
+		return 0xFF
+	}
+	return m.ram[ramBankAddress(m.ram, 0, address)]
+}
+
+func (m *romOnly) WriteRAM(address uint16, value byte) {
+	if len(m.ram) == 0 {
+		return
+	}
+	m.ram[ramBankAddress(m.ram, 0, address)] = value
+}
+
+func (m *romOnly) SaveData() []byte {
+	return saveRAM(m.ram, m.battery)
+}
+
+func (m *romOnly) LoadSaveData(data []byte) {
+	copy(m.ram, data)
+}
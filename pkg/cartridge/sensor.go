@@ -0,0 +1,143 @@
+package cartridge
+
+import (
+	"image"
+	"image/color"
+)
+
+// The part of the M64282FP's picture that the Pocket Camera keeps
+const (
+	SensorWidth  = 128
+	SensorHeight = 112
+)
+
+// Exposure is scaled so that 0x1000 leaves the light level as it is
+const sensorUnitExposure = 0x1000
+
+// Edge enhancement ratios selected by bits 4-6 of register 4
+var edgeRatios = [8]float64{0.5, 0.75, 1, 1.25, 2, 3, 4, 5}
+
+// sensorImage is the brightness of the light falling on each pixel
+type sensorImage [SensorHeight][SensorWidth]byte
+
+// newSensorImage crops a picture to the sensor's shape around its centre and
+// shrinks it, averaging the pixels that fall into each sensor pixel
+func newSensorImage(picture image.Image) *sensorImage {
+	bounds := picture.Bounds()
+	if gray, ok := picture.(*image.Gray); ok && bounds.Dx() == SensorWidth && bounds.Dy() == SensorHeight {
+		s := &sensorImage{}
+		for y := range s {
+			copy(s[y][:], gray.Pix[y*gray.Stride:])
+		}
+		return s
+	}
+	width, height := bounds.Dx(), bounds.Dy()
+	if width*SensorHeight > height*SensorWidth {
+		width = height * SensorWidth / SensorHeight
+	} else {
+		height = width * SensorHeight / SensorWidth
+	}
+	left := bounds.Min.X + (bounds.Dx()-width)/2
+	top := bounds.Min.Y + (bounds.Dy()-height)/2
+
+	s := &sensorImage{}
+	if width == 0 || height == 0 {
+		return s
+	}
+	for y := 0; y < SensorHeight; y++ {
+		y0, y1 := top+y*height/SensorHeight, top+(y+1)*height/SensorHeight
+		if y1 == y0 {
+			y1++
+		}
+		for x := 0; x < SensorWidth; x++ {
+			x0, x1 := left+x*width/SensorWidth, left+(x+1)*width/SensorWidth
+			if x1 == x0 {
+				x1++
+			}
+			var total, count uint
+			for py := y0; py < y1; py++ {
+				for px := x0; px < x1; px++ {
+					total += uint(color.GrayModel.Convert(picture.At(px, py)).(color.Gray).Y)
+					count++
+				}
+			}
+			s[y][x] = byte(total / count)
+		}
+	}
+	return s
+}
+
+func (s *sensorImage) gray() *image.Gray {
+	picture := image.NewGray(image.Rect(0, 0, SensorWidth, SensorHeight))
+	for y := range s {
+		copy(picture.Pix[y*picture.Stride:], s[y][:])
+	}
+	return picture
+}
+
+// capture runs a picture through the sensor and the cartridge's dithering
+// and returns it as 2bpp tiles, 16 across and 14 down
+func capture(picture *sensorImage, registers *[cameraRegisters]byte) [SensorWidth * SensorHeight / 4]byte {
+	exposure := float64(uint(registers[cameraExposureHigh])<<8|uint(registers[cameraExposureLow])) / sensorUnitExposure
+	var exposed [SensorHeight][SensorWidth]float64
+	for y := range picture {
+		for x, light := range picture[y] {
+			exposed[y][x] = float64(light) * exposure
+		}
+	}
+
+	// VH selects which neighbours the edge enhancement compares against
+	vertical := registers[cameraEdgeAndGain]&0x40 != 0
+	horizontal := registers[cameraEdgeAndGain]&0x20 != 0
+	ratio := edgeRatios[(registers[cameraEdgeRatio]>>4)&0x7]
+	at := func(x, y int) float64 {
+		return exposed[clampIndex(y, SensorHeight)][clampIndex(x, SensorWidth)]
+	}
+
+	var tiles [SensorWidth * SensorHeight / 4]byte
+	for y := 0; y < SensorHeight; y++ {
+		for x := 0; x < SensorWidth; x++ {
+			value := exposed[y][x]
+			var edge float64
+			if horizontal {
+				edge += 2*value - at(x-1, y) - at(x+1, y)
+			}
+			if vertical {
+				edge += 2*value - at(x, y-1) - at(x, y+1)
+			}
+			value += edge * ratio
+
+			// Each pixel in a 4x4 block has its own three thresholds
+			thresholds := registers[cameraMatrix+((y&3)*4+(x&3))*3:]
+			var shade byte
+			switch {
+			case value < float64(thresholds[0]):
+				shade = 3
+			case value < float64(thresholds[1]):
+				shade = 2
+			case value < float64(thresholds[2]):
+				shade = 1
+			}
+
+			row := ((y/8)*(SensorWidth/8)+x/8)*16 + (y%8)*2
+			bit := byte(0x80) >> uint(x%8)
+			if shade&0x1 != 0 {
+				tiles[row] |= bit
+			}
+			if shade&0x2 != 0 {
+				tiles[row+1] |= bit
+			}
+		}
+	}
+	return tiles
+}
+
+func clampIndex(i, length int) int {
+	switch {
+	case i < 0:
+		return 0
+	case i >= length:
+		return length - 1
+	}
+	return i
+}
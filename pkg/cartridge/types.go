@@ -0,0 +1,109 @@
+package cartridge
+
+import "strings"
+
+// Controller is the memory bank controller or other chip that maps the
+// cartridge into the address space
+type Controller byte
+
+const (
+	NoController Controller = iota
+	MBC1
+	MBC2
+	MMM01
+	MBC3
+	MBC5
+	MBC6
+	MBC7
+	PocketCamera
+	TAMA5
+	HuC3
+	HuC1
+)
+
+var controllerNames = [...]string{
+	NoController: "ROM",
+	MBC1:         "MBC1",
+	MBC2:         "MBC2",
+	MMM01:        "MMM01",
+	MBC3:         "MBC3",
+	MBC5:         "MBC5",
+	MBC6:         "MBC6",
+	MBC7:         "MBC7",
+	PocketCamera: "POCKET CAMERA",
+	TAMA5:        "BANDAI TAMA5",
+	HuC3:         "HuC3",
+	HuC1:         "HuC1",
+}
+
+func (c Controller) String() string {
+	if int(c) < len(controllerNames) {
+		return controllerNames[c]
+	}
+	return "unknown"
+}
+
+// Features describes the hardware on a cartridge
+type Features struct {
+	Controller Controller
+	RAM        bool
+	Battery    bool
+	RTC        bool
+	Rumble     bool
+	Sensor     bool
+}
+
+func (f Features) String() string {
+	parts := []string{f.Controller.String()}
+	if f.RTC {
+		parts = append(parts, "TIMER")
+	}
+	if f.Sensor {
+		parts = append(parts, "SENSOR")
+	}
+	if f.Rumble {
+		parts = append(parts, "RUMBLE")
+	}
+	if f.RAM {
+		parts = append(parts, "RAM")
+	}
+	if f.Battery {
+		parts = append(parts, "BATTERY")
+	}
+	if f.Controller == NoController && len(parts) == 1 {
+		return "ROM ONLY"
+	}
+	return strings.Join(parts, "+")
+}
+
+// Cartridge type codes at 0x147, from Pan Docs
+var cartridgeTypes = map[byte]Features{
+	0x00: {Controller: NoController},
+	0x01: {Controller: MBC1},
+	0x02: {Controller: MBC1, RAM: true},
+	0x03: {Controller: MBC1, RAM: true, Battery: true},
+	0x05: {Controller: MBC2},
+	0x06: {Controller: MBC2, Battery: true},
+	0x08: {Controller: NoController, RAM: true},
+	0x09: {Controller: NoController, RAM: true, Battery: true},
+	0x0B: {Controller: MMM01},
+	0x0C: {Controller: MMM01, RAM: true},
+	0x0D: {Controller: MMM01, RAM: true, Battery: true},
+	0x0F: {Controller: MBC3, RTC: true, Battery: true},
+	0x10: {Controller: MBC3, RTC: true, RAM: true, Battery: true},
+	0x11: {Controller: MBC3},
+	0x12: {Controller: MBC3, RAM: true},
+	0x13: {Controller: MBC3, RAM: true, Battery: true},
+	0x19: {Controller: MBC5},
+	0x1A: {Controller: MBC5, RAM: true},
+	0x1B: {Controller: MBC5, RAM: true, Battery: true},
+	0x1C: {Controller: MBC5, Rumble: true},
+	0x1D: {Controller: MBC5, Rumble: true, RAM: true},
+	0x1E: {Controller: MBC5, Rumble: true, RAM: true, Battery: true},
+	0x20: {Controller: MBC6},
+	0x22: {Controller: MBC7, Sensor: true, Rumble: true, RAM: true, Battery: true},
+	0xFC: {Controller: PocketCamera, RAM: true, Battery: true},
+	0xFD: {Controller: TAMA5},
+	0xFE: {Controller: HuC3, RTC: true, RAM: true, Battery: true},
+	0xFF: {Controller: HuC1, RAM: true, Battery: true},
+}
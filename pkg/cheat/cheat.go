@@ -0,0 +1,132 @@
+// Package cheat parses Game Genie and GameShark codes and keeps track of
+// which are turned on
+package cheat
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type Kind byte
+
+const (
+	// GameGenie codes change what the game reads from ROM
+	GameGenie Kind = iota
+	// GameShark codes write to RAM once a frame
+	GameShark
+)
+
+func (k Kind) String() string {
+	if k == GameShark {
+		return "GameShark"
+	}
+	return "Game Genie"
+}
+
+const (
+	gameGenieShortLength = 6
+	gameGenieLength      = 9
+	gameSharkLength      = 8
+
+	romEnd       = 0x7FFF
+	cartRAMStart = 0xA000
+	cartRAMEnd   = 0xBFFF
+
+	// GameShark types: 01 writes to whatever is mapped, 8x to RAM bank x
+	gameSharkWrite  = 0x01
+	gameSharkBanked = 0x80
+)
+
+var ErrInvalidCode = errors.New("invalid cheat code")
+
+// Cheat is a parsed Game Genie or GameShark code
+type Cheat struct {
+	// Code is the code in its usual form, such as 00A-17B-C49 or 01FF18C1
+	Code    string
+	Name    string
+	Kind    Kind
+	Enabled bool
+	Address uint16
+	Value   byte
+	// A Game Genie code with a compare byte only patches the ROM where it
+	// holds Compare, so it only affects the right bank
+	Compare    byte
+	HasCompare bool
+	// A GameShark code with a bank writes to that bank of cartridge RAM
+	// whichever is selected
+	Bank   uint
+	Banked bool
+}
+
+// Parse reads a Game Genie code of the form ABC-DEF or ABC-DEF-GHI, or an
+// 8-digit GameShark code. Dashes and spaces are optional. Errors wrap
+// ErrInvalidCode.
+func Parse(code string) (Cheat, error) {
+	digits := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if _, err := strconv.ParseUint(digits, 16, 64); err != nil {
+		return Cheat{}, errors.Wrapf(ErrInvalidCode, "%q isn't hexadecimal", code)
+	}
+	switch len(digits) {
+	case gameGenieShortLength, gameGenieLength:
+		return parseGameGenie(digits)
+	case gameSharkLength:
+		return parseGameShark(digits)
+	}
+	return Cheat{}, errors.Wrapf(ErrInvalidCode, "%q is the wrong length", code)
+}
+
+// parseGameGenie decodes ABC-DEF-GHI. AB is the new value and the address is
+// the complement of F followed by CDE. Rotating GI right by 2 and XORing it
+// with BA gives the compare byte. H isn't used.
+func parseGameGenie(digits string) (Cheat, error) {
+	n := func(i int) uint16 {
+		value, _ := strconv.ParseUint(digits[i:i+1], 16, 8)
+		return uint16(value)
+	}
+	c := Cheat{
+		Kind:    GameGenie,
+		Value:   byte(n(0)<<4 | n(1)),
+		Address: (n(5)^0xF)<<12 | n(2)<<8 | n(3)<<4 | n(4),
+		Code:    digits[0:3] + "-" + digits[3:6],
+	}
+	if c.Address > romEnd {
+		return Cheat{}, errors.Wrapf(ErrInvalidCode, "Game Genie address %#04x is outside ROM", c.Address)
+	}
+	if len(digits) == gameGenieLength {
+		gi := byte(n(6)<<4 | n(8))
+		c.Compare = (gi>>2 | gi<<6) ^ 0xBA
+		c.HasCompare = true
+		c.Code += "-" + digits[6:9]
+	}
+	return c, nil
+}
+
+// parseGameShark decodes TTVVLLHH: a type, the value and the address low
+// byte first
+func parseGameShark(digits string) (Cheat, error) {
+	value, _ := strconv.ParseUint(digits, 16, 32)
+	c := Cheat{
+		Kind:    GameShark,
+		Code:    digits,
+		Value:   byte(value >> 16),
+		Address: uint16(value>>8&0xFF) | uint16(value&0xFF)<<8,
+	}
+	kind := byte(value >> 24)
+	switch {
+	case kind == gameSharkWrite:
+	case kind&0xF0 == gameSharkBanked:
+		c.Bank = uint(kind & 0xF)
+		c.Banked = true
+		if c.Address < cartRAMStart || c.Address > cartRAMEnd {
+			return Cheat{}, errors.Wrapf(ErrInvalidCode, "GameShark bank given for %#04x outside cartridge RAM", c.Address)
+		}
+	default:
+		return Cheat{}, errors.Wrapf(ErrInvalidCode, "unknown GameShark type %02X", kind)
+	}
+	if c.Address <= romEnd {
+		return Cheat{}, errors.Wrapf(ErrInvalidCode, "GameShark address %#04x is in ROM", c.Address)
+	}
+	return c, nil
+}
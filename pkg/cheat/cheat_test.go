@@ -0,0 +1,112 @@
+package cheat
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		code     string
+		expected Cheat
+	}{
+		{"3EA-18F-E6E", Cheat{Code: "3EA-18F-E6E", Kind: GameGenie, Address: 0x0A18, Value: 0x3E, Compare: 0x01, HasCompare: true}},
+		{"3ea18fe6e", Cheat{Code: "3EA-18F-E6E", Kind: GameGenie, Address: 0x0A18, Value: 0x3E, Compare: 0x01, HasCompare: true}},
+		{"00A-17B", Cheat{Code: "00A-17B", Kind: GameGenie, Address: 0x4A17, Value: 0x00}},
+		{"01FF18C1", Cheat{Code: "01FF18C1", Kind: GameShark, Address: 0xC118, Value: 0xFF}},
+		{"83 05 00 A1", Cheat{Code: "830500A1", Kind: GameShark, Address: 0xA100, Value: 0x05, Bank: 3, Banked: true}},
+	}
+	for _, test := range testCases {
+		actual, err := Parse(test.code)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", test.code, err)
+		} else if actual != test.expected {
+			t.Errorf("%s: expected %+v, got %+v", test.code, test.expected, actual)
+		}
+	}
+
+	for _, code := range []string{"", "XYZ-123", "12345", "3EA-180-E6E", "02FF18C1", "8305FFC1", "01FF0040"} {
+		if _, err := Parse(code); errors.Cause(err) != ErrInvalidCode {
+			t.Errorf("%q: expected ErrInvalidCode, got %v", code, err)
+		}
+	}
+}
+
+func TestManager(t *testing.T) {
+	m := NewManager()
+	version := m.Version()
+	if err := m.Add("01FF18C1", "Lives"); err != nil {
+		t.Fatal(err)
+	}
+	m.Add("00A-17B", "Jump")
+	m.Add("01 FF 18 C1", "Infinite lives")
+	if m.Version() == version {
+		t.Errorf("Expected adding cheats to change the version")
+	}
+	if cheats := m.List(); len(cheats) != 2 || cheats[0].Name != "Infinite lives" {
+		t.Errorf("Expected adding a code again to rename it, got %+v", cheats)
+	}
+
+	version = m.Version()
+	if err := m.SetEnabled("01ff18c1", false); err != nil {
+		t.Fatal(err)
+	}
+	if m.Version() == version {
+		t.Errorf("Expected turning a cheat off to change the version")
+	}
+	if cheats := m.Enabled(GameShark); len(cheats) != 0 {
+		t.Errorf("Expected no GameShark codes on, got %+v", cheats)
+	}
+	if cheats := m.Enabled(GameGenie); len(cheats) != 1 || cheats[0].Code != "00A-17B" {
+		t.Errorf("Expected 00A-17B on, got %+v", cheats)
+	}
+
+	if err := m.Remove("00A17B"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Remove("00A-17B"); errors.Cause(err) != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if err := m.SetEnabled("123-456", true); errors.Cause(err) != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestCheatsFile(t *testing.T) {
+	file := `# Super Mario Land
+01FF18C1 Infinite lives
+!00A-17B Moon jump
+
+3EA-18F-E6E
+`
+	m := NewManager()
+	if err := m.Load(strings.NewReader(file)); err != nil {
+		t.Fatal(err)
+	}
+	cheats := m.List()
+	if len(cheats) != 3 {
+		t.Fatalf("Expected 3 cheats, got %d", len(cheats))
+	}
+	if !cheats[0].Enabled || cheats[0].Name != "Infinite lives" {
+		t.Errorf("Expected Infinite lives on, got %+v", cheats[0])
+	}
+	if cheats[1].Enabled || cheats[1].Name != "Moon jump" {
+		t.Errorf("Expected Moon jump off, got %+v", cheats[1])
+	}
+
+	var saved bytes.Buffer
+	if err := m.Save(&saved); err != nil {
+		t.Fatal(err)
+	}
+	expected := "01FF18C1 Infinite lives\n!00A-17B Moon jump\n3EA-18F-E6E\n"
+	if saved.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, saved.String())
+	}
+
+	if err := NewManager().Load(strings.NewReader("01FF18C1\nnonsense\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Expected an error on line 2, got %v", err)
+	}
+}
@@ -0,0 +1,186 @@
+package cheat
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// In a cheats file, a line starting with this is turned off
+const disabledPrefix = "!"
+
+var ErrNotFound = errors.New("no such cheat")
+
+// Manager holds the cheats for a game and which of them are on. It is safe
+// to change from any goroutine while the game runs.
+type Manager struct {
+	mutex   sync.Mutex
+	cheats  []Cheat
+	version uint
+}
+
+func NewManager() *Manager {
+	return &Manager{}
+}
+
+// Add parses a code and turns it on. Adding a code that is already there
+// turns it on and gives it the new name.
+func (m *Manager) Add(code, name string) error {
+	c, err := Parse(code)
+	if err != nil {
+		return err
+	}
+	c.Name = name
+	c.Enabled = true
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.version++
+	if i := m.find(c.Code); i >= 0 {
+		m.cheats[i] = c
+		return nil
+	}
+	m.cheats = append(m.cheats, c)
+	return nil
+}
+
+// Remove deletes a code. Errors wrap ErrNotFound.
+func (m *Manager) Remove(code string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.find(code)
+	if i < 0 {
+		return errors.Wrapf(ErrNotFound, "%s", code)
+	}
+	m.cheats = append(m.cheats[:i], m.cheats[i+1:]...)
+	m.version++
+	return nil
+}
+
+// SetEnabled turns a code on or off. Errors wrap ErrNotFound.
+func (m *Manager) SetEnabled(code string, enabled bool) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	i := m.find(code)
+	if i < 0 {
+		return errors.Wrapf(ErrNotFound, "%s", code)
+	}
+	m.cheats[i].Enabled = enabled
+	m.version++
+	return nil
+}
+
+// find returns the index of a code however it is written, or -1
+func (m *Manager) find(code string) int {
+	c, err := Parse(code)
+	if err != nil {
+		return -1
+	}
+	for i := range m.cheats {
+		if m.cheats[i].Code == c.Code {
+			return i
+		}
+	}
+	return -1
+}
+
+// List returns every cheat in the order they were added
+func (m *Manager) List() []Cheat {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]Cheat{}, m.cheats...)
+}
+
+// Enabled returns the cheats of a kind that are on
+func (m *Manager) Enabled(kind Kind) []Cheat {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var cheats []Cheat
+	for _, c := range m.cheats {
+		if c.Enabled && c.Kind == kind {
+			cheats = append(cheats, c)
+		}
+	}
+	return cheats
+}
+
+// Version changes whenever a cheat is added, removed, turned on or turned
+// off, so users can tell when to fetch them again
+func (m *Manager) Version() uint {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.version
+}
+
+// Load adds the cheats in a cheats file. Each line holds a code and then its
+// name. Lines starting with ! are turned off, and lines starting with # are
+// comments.
+func (m *Manager) Load(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		enabled := !strings.HasPrefix(text, disabledPrefix)
+		text = strings.TrimSpace(strings.TrimPrefix(text, disabledPrefix))
+		fields := strings.SplitN(text, " ", 2)
+		name := ""
+		if len(fields) == 2 {
+			name = strings.TrimSpace(fields[1])
+		}
+		if err := m.Add(fields[0], name); err != nil {
+			return errors.Wrapf(err, "line %d", line)
+		}
+		if !enabled {
+			m.SetEnabled(fields[0], false)
+		}
+	}
+	return errors.Wrap(scanner.Err(), "couldn't read cheats")
+}
+
+// Save writes the cheats in the form Load reads
+func (m *Manager) Save(w io.Writer) error {
+	for _, c := range m.List() {
+		prefix := ""
+		if !c.Enabled {
+			prefix = disabledPrefix
+		}
+		line := strings.TrimSpace(fmt.Sprintf("%s%s %s", prefix, c.Code, c.Name))
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return errors.Wrap(err, "couldn't write cheats")
+		}
+	}
+	return nil
+}
+
+// LoadFile adds the cheats in a cheats file. A missing file has no cheats.
+func (m *Manager) LoadFile(path string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "couldn't open cheats")
+	}
+	defer file.Close()
+	return errors.Wrapf(m.Load(file), "%s", path)
+}
+
+// SaveFile writes the cheats to a cheats file
+func (m *Manager) SaveFile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "couldn't create cheats")
+	}
+	if err := m.Save(file); err != nil {
+		file.Close()
+		return err
+	}
+	return errors.Wrap(file.Close(), "couldn't write cheats")
+}
@@ -2,7 +2,11 @@ package cpu
 
 import (
 	"fmt"
+	"image/color"
+	"io"
 
+	"github.com/tbtommyb/goboy/pkg/cartridge"
+	"github.com/tbtommyb/goboy/pkg/cheat"
 	c "github.com/tbtommyb/goboy/pkg/constants"
 	"github.com/tbtommyb/goboy/pkg/decoder"
 	"github.com/tbtommyb/goboy/pkg/display"
@@ -12,43 +16,99 @@ import (
 	"github.com/tbtommyb/goboy/pkg/utils"
 )
 
-var GameboyClockSpeed = 4194304 / 4 // four clocks per op
-
 const ClocksPerCycle uint = 4
 
+// A frame is 154 scanlines of 456 clocks
+const (
+	ClockSpeed     uint = 4194304
+	ClocksPerFrame      = 70224
+)
+
 type CPU struct {
-	r                    *registers.Registers
-	flags                byte
-	SP, PC               uint16
-	memory               MemoryInterface
-	cycles               uint
-	requestIME           bool
-	IME                  bool
-	halt                 bool
-	stop                 bool
-	Display              *display.Display
-	gpu                  *GPU
-	loadBIOS             bool
-	internalTimer        uint16
-	cyclesForCurrentTick int
-	joypadInternalState  Joypad
+	r                   *registers.Registers
+	flags               byte
+	SP, PC              uint16
+	memory              MemoryInterface
+	cycles              uint
+	requestIME          bool
+	IME                 bool
+	halt                bool
+	haltBug             bool
+	stop                bool
+	Display             *display.Display
+	gpu                 *GPU
+	model               Model
+	loadBIOS            bool
+	scheduler           *scheduler
+	decoder             decoder.Decoder
+	timerBase           uint64
+	timaReloadWindowEnd uint64
+	joypadInternalState Joypad
 }
 
 type MemoryInterface interface {
 	Get(address uint16) byte
 	Set(address uint16, value byte)
 	LoadBIOS(program []byte)
-	LoadROM(program []byte)
+	LoadROM(program []byte) error
+	BeginDMA() uint
+	TransferDMA() uint
+	SaveData() []byte
+	LoadSaveData(data []byte)
+	SetSerialOutput(w io.Writer)
+	ConnectInfrared(ir cartridge.Infrared)
+	ConnectCamera(source cartridge.ImageSource)
+	SetAcceleration(x, y float64)
+	SetGameGenie(cheats []cheat.Cheat)
+	ApplyGameShark(cheats []cheat.Cheat)
 }
 
+// RunFor runs the rest of the system for a number of clocks. Components only
+// run when an event they scheduled falls due.
 func (cpu *CPU) RunFor(cycles uint) {
-	for cycle := uint(0); cycle < cycles; cycle++ {
-		cpu.UpdateTimers()
-		cpu.UpdateDisplay()
+	if cpu.stop {
+		cpu.pauseTimers(cycles)
+		cpu.gpu.pause(cycles)
 	}
-	return
+	cpu.scheduler.runFor(cycles, cpu.handleEvent)
+}
+
+func (cpu *CPU) handleEvent(kind eventKind) {
+	switch kind {
+	case timerReloadEvent:
+		cpu.reloadTIMA()
+	case timerEdgeEvent:
+		cpu.handleTimerEdge()
+	case displayEvent:
+		cpu.gpu.update()
+	case dmaStartEvent:
+		cpu.scheduleDMATransfer(cpu.memory.BeginDMA())
+	case dmaTransferEvent:
+		cpu.scheduleDMATransfer(cpu.memory.TransferDMA())
+	}
+}
+
+// ScheduleDMA starts a DMA transfer after a delay, leaving any transfer in
+// progress running until then
+func (cpu *CPU) ScheduleDMA(delay uint) {
+	cpu.scheduler.schedule(dmaStartEvent, delay)
+}
+
+// Clocks returns the number of clocks since power on
+func (cpu *CPU) Clocks() uint64 {
+	return cpu.scheduler.now
 }
 
+func (cpu *CPU) scheduleDMATransfer(delay uint) {
+	if delay == 0 {
+		cpu.scheduler.cancel(dmaTransferEvent)
+		return
+	}
+	cpu.scheduler.schedule(dmaTransferEvent, delay)
+}
+
+// Step executes one instruction, running the rest of the system alongside
+// each M-cycle, and returns the number of clocks that elapsed.
 func (cpu *CPU) Step() uint {
 	if cpu.requestIME {
 		cpu.enableInterrupts()
@@ -56,11 +116,15 @@ func (cpu *CPU) Step() uint {
 	}
 
 	if cpu.halt {
-		return ClocksPerCycle // nop
+		cpu.incrementCycles() // nop
+		return ClocksPerCycle
 	}
 	if cpu.stop {
+		if !cpu.isJoypadLineLow() {
+			cpu.incrementCycles() // nop
+			return ClocksPerCycle
+		}
 		cpu.stop = false
-		return ClocksPerCycle // nop
 	}
 
 	// TODO: find more efficient solution
@@ -69,17 +133,18 @@ func (cpu *CPU) Step() uint {
 	}
 
 	initialCycles := cpu.GetCycles()
-	instr := decoder.Decode(cpu)
+	instr := cpu.decoder.Decode(cpu)
 	cpu.Execute(instr)
 	return ClocksPerCycle * (cpu.GetCycles() - initialCycles)
 }
 
 func Init(loadBIOS bool) *CPU {
 	cpu := &CPU{
-		loadBIOS:      loadBIOS,
-		r:             registers.Init(),
-		internalTimer: 0xABCC,
+		loadBIOS:  loadBIOS,
+		r:         registers.Init(),
+		scheduler: newScheduler(),
 	}
+	cpu.setInternalTimer(0xABCC)
 	memory := memory.Init(cpu)
 	cpu.memory = memory
 	gpu := InitGPU(cpu)
@@ -99,7 +164,6 @@ func (cpu *CPU) incrementPC() {
 }
 
 func (cpu *CPU) setPC(value uint16) {
-	cpu.incrementCycles()
 	cpu.PC = value
 }
 
@@ -107,12 +171,10 @@ func (cpu *CPU) GetCycles() uint {
 	return cpu.cycles
 }
 
+// incrementCycles advances the rest of the system by one M-cycle
 func (cpu *CPU) incrementCycles() {
 	cpu.cycles += 1
-}
-
-func (cpu *CPU) decrementCycles() {
-	cpu.cycles -= 1
+	cpu.RunFor(ClocksPerCycle)
 }
 
 func (cpu *CPU) Execute(instr in.Instruction) {
@@ -143,10 +205,10 @@ func (cpu *CPU) Execute(instr in.Instruction) {
 	case in.StoreRelativeImmediateNN:
 		cpu.WriteMem(i.Immediate, cpu.Get(registers.A))
 	case in.LoadIncrement:
-		cpu.Set(registers.A, cpu.GetMem(registers.HL))
+		cpu.Set(registers.A, cpu.readMemIncrement(cpu.GetHL()))
 		cpu.SetHL(cpu.GetHL() + 1)
 	case in.LoadDecrement:
-		cpu.Set(registers.A, cpu.GetMem(registers.HL))
+		cpu.Set(registers.A, cpu.readMemIncrement(cpu.GetHL()))
 		cpu.SetHL(cpu.GetHL() - 1)
 	case in.StoreIncrement:
 		cpu.SetMem(registers.HL, cpu.Get(registers.A))
@@ -158,20 +220,20 @@ func (cpu *CPU) Execute(instr in.Instruction) {
 		cpu.SetPair(i.Dest, i.Immediate)
 	case in.HLtoSP:
 		cpu.setSP(cpu.GetHL())
+		cpu.incrementCycles()
 	case in.Push:
 		high, low := cpu.GetPair(i.Source)
+		cpu.incrementAddress(cpu.GetSP())
 		cpu.pushStack(high)
 		cpu.pushStack(low)
-		cpu.incrementCycles() // TODO: remove need for this
 	case in.Pop:
-		low := cpu.popStack()
-		high := cpu.popStack()
+		high, low := cpu.popPair()
 		cpu.SetPair(i.Dest, utils.MergePair(high, low))
 	case in.LoadHLSP:
 		a := uint16(i.Immediate)
 		b := cpu.GetSP()
 		cpu.SetHL(a + b)
-		cpu.incrementCycles() // TODO: remove need for this
+		cpu.incrementCycles()
 		cpu.setFlags(FlagSet{
 			HalfCarry: lowerByteHalfCarry(byte(a), byte(b)),
 			FullCarry: lowerByteFullCarry(byte(a), byte(b)),
@@ -252,14 +314,15 @@ func (cpu *CPU) Execute(instr in.Instruction) {
 			FullCarry: lowerByteFullCarry(byte(a), byte(b)),
 		})
 		cpu.incrementCycles()
+		cpu.incrementCycles()
 	case in.IncrementPair:
 		a := utils.MergePair(cpu.GetPair(i.Dest))
 		cpu.SetPair(i.Dest, a+1)
-		cpu.incrementCycles()
+		cpu.incrementAddress(a)
 	case in.DecrementPair:
 		a := utils.MergePair(cpu.GetPair(i.Dest))
 		cpu.SetPair(i.Dest, a-1)
-		cpu.incrementCycles()
+		cpu.incrementAddress(a)
 	case in.RL:
 		value, flags := rotateLeftOp(cpu.Get(i.Source), cpu.isSet(FullCarry))
 		cpu.Set(i.Source, value)
@@ -320,47 +383,37 @@ func (cpu *CPU) Execute(instr in.Instruction) {
 		result := utils.SetBit(bit, cpu.Get(i.Source), 0)
 		cpu.Set(i.Source, result)
 	case in.JumpImmediate:
-		cpu.setPC(i.Immediate)
+		cpu.jump(i.Immediate)
 	case in.JumpImmediateConditional:
 		if cpu.conditionMet(i.Condition) {
-			cpu.setPC(i.Immediate)
+			cpu.jump(i.Immediate)
 		}
 	case in.JumpRelative:
-		cpu.setPC(cpu.GetPC() + uint16(i.Immediate))
+		cpu.jump(cpu.GetPC() + uint16(i.Immediate))
 	case in.JumpRelativeConditional:
 		if cpu.conditionMet(i.Condition) {
-			cpu.setPC(cpu.GetPC() + uint16(i.Immediate))
+			cpu.jump(cpu.GetPC() + uint16(i.Immediate))
 		}
 	case in.JumpMemory:
 		cpu.setPC(cpu.GetHL())
-		cpu.decrementCycles() // TODO: hack attack
 	case in.Call:
-		high, low := utils.SplitPair(cpu.GetPC())
-		cpu.pushStack(high)
-		cpu.pushStack(low)
-		cpu.setPC(i.Immediate)
+		cpu.call(i.Immediate)
 	case in.CallConditional:
 		if cpu.conditionMet(i.Condition) {
-			high, low := utils.SplitPair(cpu.GetPC())
-			cpu.pushStack(high)
-			cpu.pushStack(low)
-			cpu.setPC(i.Immediate)
+			cpu.call(i.Immediate)
 		}
 	case in.Return:
-		cpu.setPC(utils.ReverseMergePair(cpu.popStack(), cpu.popStack()))
+		cpu.ret()
 	case in.ReturnInterrupt:
-		cpu.setPC(utils.ReverseMergePair(cpu.popStack(), cpu.popStack()))
+		cpu.ret()
 		cpu.requestIME = true
 	case in.ReturnConditional:
+		cpu.incrementCycles()
 		if cpu.conditionMet(i.Condition) {
-			cpu.setPC(utils.ReverseMergePair(cpu.popStack(), cpu.popStack()))
+			cpu.ret()
 		}
-		cpu.incrementCycles()
 	case in.RST:
-		high, low := utils.SplitPair(cpu.GetPC())
-		cpu.pushStack(high)
-		cpu.pushStack(low)
-		cpu.setPC(uint16(i.Operand << in.OperandShift))
+		cpu.call(uint16(i.Operand << in.OperandShift))
 	case in.DAA:
 		if !cpu.isSet(Negative) {
 			if cpu.isSet(FullCarry) || cpu.Get(registers.A) > 0x99 {
@@ -414,24 +467,107 @@ func (cpu *CPU) Execute(instr in.Instruction) {
 	case in.Nop:
 	case in.Stop:
 		cpu.stop = true
+		cpu.ResetInternalTimer()
 	case in.Halt:
+		if !cpu.interruptsEnabled() && cpu.pendingInterrupts() != 0 {
+			// HALT bug: the next opcode is fetched without incrementing PC
+			cpu.haltBug = true
+			return
+		}
 		cpu.halt = true
 	case in.InvalidInstruction:
-		fmt.Sprintf("Invalid Instruction: %x", instr.Opcode())
+		panic(cpu.crash(fmt.Sprintf("invalid opcode %02X", i.ErrorOpcode)))
 	}
 }
 
+func (cpu *CPU) jump(address uint16) {
+	cpu.setPC(address)
+	cpu.incrementCycles()
+}
+
+func (cpu *CPU) call(address uint16) {
+	high, low := utils.SplitPair(cpu.GetPC())
+	cpu.incrementAddress(cpu.GetSP())
+	cpu.pushStack(high)
+	cpu.pushStack(low)
+	cpu.setPC(address)
+}
+
+func (cpu *CPU) ret() {
+	cpu.setPC(utils.MergePair(cpu.popPair()))
+	cpu.incrementCycles()
+}
+
 func (cpu *CPU) AttachDisplay(d DisplayInterface) {
 	cpu.gpu.display = d
 }
 
-func (cpu *CPU) UpdateDisplay() {
-	cpu.gpu.update()
+func (cpu *CPU) SetModel(model Model) {
+	cpu.model = model
+}
+
+// SetPalette sets the colours used for the four shades, lightest first. Nil
+// colours keep their current value.
+func (cpu *CPU) SetPalette(colours [4]color.Color) {
+	for i, colour := range colours {
+		if colour == nil {
+			continue
+		}
+		r, g, b, _ := colour.RGBA()
+		cpu.gpu.palette[i] = RGB{r: byte(r >> 8), g: byte(g >> 8), b: byte(b >> 8)}
+	}
+}
+
+// FrameCount returns the number of times the display has entered VBlank
+func (cpu *CPU) FrameCount() uint {
+	return cpu.gpu.frames
+}
+
+func (cpu *CPU) SaveData() []byte {
+	return cpu.memory.SaveData()
+}
+
+func (cpu *CPU) LoadSaveData(data []byte) {
+	cpu.memory.LoadSaveData(data)
+}
+
+func (cpu *CPU) SetSerialOutput(w io.Writer) {
+	cpu.memory.SetSerialOutput(w)
+}
+
+// ConnectInfrared connects the cartridge's IR port, if it has one. Call it
+// after LoadROM.
+func (cpu *CPU) ConnectInfrared(ir cartridge.Infrared) {
+	cpu.memory.ConnectInfrared(ir)
+}
+
+// ConnectCamera shows the cartridge's camera pictures from source, if it has
+// a camera. Call it after LoadROM.
+func (cpu *CPU) ConnectCamera(source cartridge.ImageSource) {
+	cpu.memory.ConnectCamera(source)
+}
+
+func (cpu *CPU) SetAcceleration(x, y float64) {
+	cpu.memory.SetAcceleration(x, y)
+}
+
+// SetGameGenie replaces the Game Genie codes patching ROM reads
+func (cpu *CPU) SetGameGenie(cheats []cheat.Cheat) {
+	cpu.memory.SetGameGenie(cheats)
+}
+
+// ApplyGameShark writes the values of GameShark codes to RAM
+func (cpu *CPU) ApplyGameShark(cheats []cheat.Cheat) {
+	cpu.memory.ApplyGameShark(cheats)
 }
 
 func (cpu *CPU) Next() byte {
 	value := cpu.memory.Get(cpu.GetPC())
-	cpu.incrementPC()
+	if cpu.haltBug {
+		cpu.haltBug = false
+	} else {
+		cpu.incrementPC()
+	}
 	cpu.incrementCycles()
 	return value
 }
@@ -458,16 +594,40 @@ func (cpu *CPU) emulateBootSequence() {
 
 func (cpu *CPU) WriteMem(address uint16, value byte) {
 	cpu.incrementCycles()
+	if isOAMBugAddress(address) {
+		cpu.gpu.triggerOAMWriteBug()
+	}
 	cpu.memory.Set(address, value)
 }
 
 func (cpu *CPU) readMem(address uint16) byte {
 	cpu.incrementCycles()
+	if isOAMBugAddress(address) {
+		cpu.gpu.triggerOAMReadBug()
+	}
+	return cpu.memory.Get(address)
+}
+
+// readMemIncrement reads from an address that is being incremented or
+// decremented in the same cycle
+func (cpu *CPU) readMemIncrement(address uint16) byte {
+	cpu.incrementCycles()
+	if isOAMBugAddress(address) {
+		cpu.gpu.triggerOAMReadIncreaseBug()
+	}
 	return cpu.memory.Get(address)
 }
 
-func (cpu *CPU) LoadROM(program []byte) {
-	cpu.memory.LoadROM(program)
+// incrementAddress spends a cycle incrementing or decrementing address
+func (cpu *CPU) incrementAddress(address uint16) {
+	cpu.incrementCycles()
+	if isOAMBugAddress(address) {
+		cpu.gpu.triggerOAMWriteBug()
+	}
+}
+
+func (cpu *CPU) LoadROM(program []byte) error {
+	return cpu.memory.LoadROM(program)
 }
 
 func (cpu *CPU) LoadBIOS(program []byte) {
@@ -487,6 +647,10 @@ func (cpu *CPU) WriteOAM(address uint16, value byte) {
 	cpu.gpu.writeOAM(address, value)
 }
 
+func (cpu *CPU) WriteOAMDMA(address uint16, value byte) {
+	cpu.gpu.writeOAMDMA(address, value)
+}
+
 func (cpu *CPU) ReadVRAM(address uint16) byte {
 	return cpu.gpu.readVRAM(address)
 }
@@ -495,6 +659,18 @@ func (cpu *CPU) WriteVRAM(address uint16, value byte) {
 	cpu.gpu.writeVRAM(address, value)
 }
 
+func (cpu *CPU) WriteLCDStatus(value byte) {
+	cpu.gpu.writeStatus(value)
+}
+
+func (cpu *CPU) WriteLCDControl(value byte) {
+	cpu.gpu.writeControl(GPUControl(value))
+}
+
+func (cpu *CPU) WriteLYCompare(value byte) {
+	cpu.gpu.writeLYCompare(value)
+}
+
 func (cpu *CPU) setBitAt(address uint16, bitNumber, bitValue byte) {
 	cpu.memory.Set(address, utils.SetBit(bitNumber, cpu.memory.Get(address), bitValue))
 }
@@ -2,9 +2,14 @@ package cpu
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
 	"testing"
 
+	"github.com/tbtommyb/goboy/pkg/cartridge"
+	"github.com/tbtommyb/goboy/pkg/cheat"
 	"github.com/tbtommyb/goboy/pkg/conditions"
+	c "github.com/tbtommyb/goboy/pkg/constants"
 	in "github.com/tbtommyb/goboy/pkg/instructions"
 	"github.com/tbtommyb/goboy/pkg/registers"
 )
@@ -24,18 +29,63 @@ func (m *TestMemory) Set(address uint16, value byte) {
 func (m *TestMemory) LoadBIOS(program []byte) {
 }
 
-func (m *TestMemory) LoadROM(program []byte) {
+func (m *TestMemory) BeginDMA() uint {
+	return 0
+}
+
+func (m *TestMemory) TransferDMA() uint {
+	return 0
+}
+
+func (m *TestMemory) SaveData() []byte {
+	return nil
+}
+
+func (m *TestMemory) LoadSaveData(data []byte) {}
+
+func (m *TestMemory) SetSerialOutput(w io.Writer) {}
+
+func (m *TestMemory) ConnectInfrared(ir cartridge.Infrared) {}
+
+func (m *TestMemory) ConnectCamera(source cartridge.ImageSource) {}
+
+func (m *TestMemory) SetAcceleration(x, y float64) {}
+
+func (m *TestMemory) SetGameGenie(cheats []cheat.Cheat) {}
+
+func (m *TestMemory) ApplyGameShark(cheats []cheat.Cheat) {}
+
+func (m *TestMemory) LoadROM(program []byte) error {
 	for i := 0; i < len(program); i++ {
 		m.mem[i] = program[i]
 	}
+	return nil
 }
 
 func createCPU() *CPU {
-	return &CPU{
-		memory: &TestMemory{mem: [0x10000]byte{}},
-		r:      registers.Init(),
-		SP:     0xFFFE,
+	cpu := &CPU{
+		memory:    &TestMemory{mem: [0x10000]byte{}},
+		r:         registers.Init(),
+		SP:        0xFFFE,
+		scheduler: newScheduler(),
 	}
+	cpu.gpu = InitGPU(cpu)
+	return cpu
+}
+
+// createGameboy returns a fully wired CPU with the program at the entry point
+func createGameboy(instructions []in.Instruction) *CPU {
+	cpu := Init(false)
+	rom := make([]byte, 0x8000)
+	copy(rom[0x100:], encode(instructions))
+	cpu.LoadROM(rom)
+	cpu.AttachDisplay(&TestDisplay{})
+	return cpu
+}
+
+func stepSystem(cpu *CPU) {
+	cpu.HandleInterrupts()
+	cpu.Step()
 }
 
 func run(cpu *CPU, instructions []in.Instruction) {
@@ -1719,6 +1769,228 @@ func TestStop(t *testing.T) {
 	}
 }
 
+func TestHaltExitWithoutIME(t *testing.T) {
+	cpu := createGameboy([]in.Instruction{
+		in.Halt{},
+		in.Increment{Dest: registers.A},
+	})
+	cpu.memory.Set(c.InterruptEnableAddress, 1<<TimerOverflow)
+
+	for i := 0; i < 10; i++ {
+		stepSystem(cpu)
+	}
+	if actual := cpu.GetPC(); actual != 0x101 || !cpu.halt {
+		t.Fatalf("Expected CPU to be halted at 0x101, got %x", actual)
+	}
+
+	cpu.requestInterrupt(TimerOverflow)
+	start := cpu.GetInternalTimer()
+	cpu.HandleInterrupts()
+
+	if cpu.halt {
+		t.Error("Expected pending interrupt to exit HALT")
+	}
+	if actual := cpu.GetInternalTimer() - start; actual != 0 {
+		t.Errorf("Expected no wakeup delay without IME, got %d cycles", actual)
+	}
+	stepSystem(cpu)
+	if actual := cpu.GetPC(); actual != 0x102 {
+		t.Errorf("Expected execution to continue after HALT, got PC %x", actual)
+	}
+	if actual := cpu.Get(registers.A); actual != 0x02 {
+		t.Errorf("Expected A to be incremented once, got %x", actual)
+	}
+}
+
+func TestHaltBug(t *testing.T) {
+	cpu := createGameboy([]in.Instruction{
+		in.Halt{},
+		in.Increment{Dest: registers.A},
+		in.Nop{},
+	})
+	cpu.memory.Set(c.InterruptEnableAddress, 1<<TimerOverflow)
+	cpu.requestInterrupt(TimerOverflow)
+
+	for i := 0; i < 3; i++ {
+		stepSystem(cpu)
+	}
+
+	if cpu.halt {
+		t.Error("Expected HALT to be skipped with an interrupt pending")
+	}
+	if actual := cpu.Get(registers.A); actual != 0x03 {
+		t.Errorf("Expected instruction after HALT to run twice, got A %x", actual)
+	}
+	if actual := cpu.GetPC(); actual != 0x102 {
+		t.Errorf("Expected PC %x, got %x", 0x102, actual)
+	}
+}
+
+func TestHaltWakeupTiming(t *testing.T) {
+	dispatchCycles := func(halted bool) uint16 {
+		cpu := createGameboy([]in.Instruction{in.Halt{}})
+		cpu.memory.Set(c.InterruptEnableAddress, 1<<VBlank)
+		cpu.enableInterrupts()
+		if halted {
+			stepSystem(cpu)
+		}
+		cpu.requestInterrupt(VBlank)
+		start := cpu.GetInternalTimer()
+		cpu.HandleInterrupts()
+		if actual := cpu.GetPC(); actual != VBlankInterruptHandlerAddress {
+			t.Errorf("Expected interrupt to be serviced, got PC %x", actual)
+		}
+		return cpu.GetInternalTimer() - start
+	}
+
+	if actual := dispatchCycles(true) - dispatchCycles(false); actual != uint16(ClocksPerCycle) {
+		t.Errorf("Expected HALT exit to take %d extra cycles, got %d", ClocksPerCycle, actual)
+	}
+}
+
+func TestStopMode(t *testing.T) {
+	testCases := []struct {
+		selection byte
+		wakes     bool
+	}{
+		{selection: 0x00, wakes: true},
+		{selection: 0x10, wakes: true},
+		{selection: 0x20, wakes: false},
+		{selection: 0x30, wakes: false},
+	}
+
+	for _, test := range testCases {
+		cpu := createGameboy([]in.Instruction{
+			in.Stop{},
+			in.Increment{Dest: registers.A},
+		})
+		cpu.memory.Set(c.JoypadRegisterAddress, test.selection)
+
+		stepSystem(cpu)
+		if actual := cpu.memory.Get(c.DIVAddress); actual != 0 {
+			t.Errorf("Expected STOP to reset DIV, got %x", actual)
+		}
+		for i := 0; i < 1000; i++ {
+			stepSystem(cpu)
+		}
+		if actual := cpu.GetInternalTimer(); actual != 0 {
+			t.Errorf("Expected timer to be stopped, got %x", actual)
+		}
+		if actual := cpu.GetPC(); actual != 0x102 {
+			t.Errorf("Expected CPU to stay stopped, got PC %x", actual)
+		}
+
+		cpu.PressButton(ButtonA)
+		stepSystem(cpu)
+
+		if woken := !cpu.stop; woken != test.wakes {
+			t.Errorf("P1 %x: expected wake to be %t", test.selection, test.wakes)
+		}
+	}
+}
+
+func TestInterruptDispatch(t *testing.T) {
+	cpu := createGameboy([]in.Instruction{in.Nop{}})
+	cpu.memory.Set(c.InterruptEnableAddress, 1<<VBlank|1<<TimerOverflow)
+	cpu.requestInterrupt(TimerOverflow)
+	cpu.requestInterrupt(VBlank)
+	cpu.enableInterrupts()
+	cpu.SP = 0xD000
+	cpu.PC = 0x1234
+
+	start := cpu.GetInternalTimer()
+	cpu.HandleInterrupts()
+
+	if actual := cpu.GetInternalTimer() - start; actual != uint16(5*ClocksPerCycle) {
+		t.Errorf("Expected dispatch to take 5 M-cycles, got %d cycles", actual)
+	}
+	if actual := cpu.GetPC(); actual != VBlankInterruptHandlerAddress {
+		t.Errorf("Expected VBlank to be serviced first, got PC %x", actual)
+	}
+	if actual := cpu.memory.Get(c.InterruptFlagAddress) & InterruptMask; actual != 1<<TimerOverflow {
+		t.Errorf("Expected only timer interrupt to remain requested, got %x", actual)
+	}
+	if cpu.interruptsEnabled() {
+		t.Error("Expected dispatch to disable IME")
+	}
+	if actual := cpu.GetSP(); actual != 0xCFFE {
+		t.Errorf("Expected SP %x, got %x", 0xCFFE, actual)
+	}
+	if high, low := cpu.memory.Get(0xCFFF), cpu.memory.Get(0xCFFE); high != 0x12 || low != 0x34 {
+		t.Errorf("Expected return address 1234 on stack, got %02x%02x", high, low)
+	}
+}
+
+func TestInterruptDispatchPushToIE(t *testing.T) {
+	testCases := []struct {
+		pc        uint16
+		enabled   byte
+		requested byte
+		expected  uint16
+		message   string
+	}{
+		{pc: 0x0100, enabled: 0x01, requested: 0x01, expected: VBlankInterruptHandlerAddress, message: "IE unchanged"},
+		{pc: 0x0200, enabled: 0x01, requested: 0x01, expected: 0x0000, message: "dispatch cancelled"},
+		{pc: 0x0200, enabled: 0x03, requested: 0x03, expected: LCDCStatusInterruptHandlerAddress, message: "lower priority chosen"},
+	}
+
+	for _, test := range testCases {
+		cpu := createGameboy([]in.Instruction{in.Nop{}})
+		cpu.memory.Set(c.InterruptEnableAddress, test.enabled)
+		cpu.memory.Set(c.InterruptFlagAddress, test.requested)
+		cpu.enableInterrupts()
+		cpu.SP = 0x0000
+		cpu.PC = test.pc
+
+		cpu.HandleInterrupts()
+
+		if actual := cpu.GetPC(); actual != test.expected {
+			t.Errorf("%s: expected PC %x, got %x", test.message, test.expected, actual)
+		}
+		if test.expected == 0x0000 {
+			if actual := cpu.memory.Get(c.InterruptFlagAddress) & InterruptMask; actual != test.requested {
+				t.Errorf("%s: expected IF to be untouched, got %x", test.message, actual)
+			}
+		}
+	}
+}
+
+func TestMemoryAccessTiming(t *testing.T) {
+	testCases := []struct {
+		instructions []in.Instruction
+		edgeCycle    uint16
+		expected     byte
+		message      string
+	}{
+		{instructions: []in.Instruction{in.LoadRelativeImmediateN{Immediate: 0x05}}, edgeCycle: 3, expected: 0x01, message: "read on third cycle"},
+		{instructions: []in.Instruction{in.LoadRelativeImmediateN{Immediate: 0x05}}, edgeCycle: 4, expected: 0x00, message: "read before fourth cycle"},
+		{instructions: []in.Instruction{in.LoadRelativeImmediateNN{Immediate: 0xFF05}}, edgeCycle: 4, expected: 0x01, message: "read on fourth cycle"},
+		{instructions: []in.Instruction{in.Increment{Dest: registers.M}}, edgeCycle: 2, expected: 0x02, message: "modify reads on second cycle"},
+		{instructions: []in.Instruction{in.Increment{Dest: registers.M}}, edgeCycle: 3, expected: 0x01, message: "modify writes on third cycle"},
+	}
+
+	for _, test := range testCases {
+		cpu := createGameboy(test.instructions)
+		cpu.memory.Set(c.TACAddress, 0x5)
+		cpu.memory.Set(c.TIMAAddress, 0)
+		cpu.SetHL(c.TIMAAddress)
+		cpu.Set(registers.A, 0)
+		// TIMA increments when the internal timer reaches a multiple of 16
+		cpu.setInternalTimer(16 - test.edgeCycle*uint16(ClocksPerCycle))
+		cpu.scheduleTimerEdge()
+
+		cpu.Step()
+
+		actual := cpu.Get(registers.A)
+		if _, ok := test.instructions[0].(in.Increment); ok {
+			actual = cpu.memory.Get(c.TIMAAddress)
+		}
+		if actual != test.expected {
+			t.Errorf("%s: expected %x, got %x", test.message, test.expected, actual)
+		}
+	}
+}
+
 func TestInstructionCycles(t *testing.T) {
 	testCases := []struct {
 		instructions []in.Instruction
@@ -1853,3 +2125,72 @@ func expectFlagSet(t *testing.T, cpu *CPU, name string, fs FlagSet) {
 		t.Errorf("%s: %s", name, err)
 	}
 }
+
+// BenchmarkFrame runs one frame of a busy loop with the display and timer
+// running. Frames per second is 1e9 divided by ns/op.
+func BenchmarkFrame(b *testing.B) {
+	cpu := createGameboy([]in.Instruction{
+		in.MoveImmediate{Dest: registers.A, Immediate: 0x5},
+		in.StoreRelativeImmediateN{Immediate: byte(c.TACAddress & 0xFF)},
+		in.Increment{Dest: registers.B},
+		in.JumpRelative{Immediate: -3},
+	})
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for clocks := uint(0); clocks < ClocksPerFrame; {
+			cpu.HandleInterrupts()
+			clocks += cpu.Step()
+		}
+	}
+}
+
+// BenchmarkStep runs instructions from a test ROM that spends most of its
+// time in ALU loops. Instructions per second is 1e9 divided by ns/op.
+func BenchmarkStep(b *testing.B) {
+	rom, err := ioutil.ReadFile("../../specs/cpu_instrs/09-op r,r.gb")
+	if err != nil {
+		b.Skip(err)
+	}
+	cpu := Init(false)
+	if err := cpu.LoadROM(rom); err != nil {
+		b.Fatal(err)
+	}
+	cpu.AttachDisplay(&TestDisplay{})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cpu.HandleInterrupts()
+		cpu.Step()
+	}
+}
+
+func TestInvalidOpcodeCrashes(t *testing.T) {
+	cpu := createCPU()
+	cpu.LoadROM([]byte{0x00, 0xD3, 0x12})
+	cpu.SetBC(0x1234)
+
+	var report *CrashReport
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				report = cpu.ReportCrash(r)
+			}
+		}()
+		for i := 0; i < 2; i++ {
+			cpu.Step()
+		}
+	}()
+
+	if report == nil {
+		t.Fatal("Expected invalid opcode to crash")
+	}
+	if expected := "invalid opcode D3"; report.Reason != expected {
+		t.Errorf("Expected reason %q, got %q", expected, report.Reason)
+	}
+	if report.PC != 0x2 || report.BC != 0x1234 {
+		t.Errorf("Expected PC 2 and BC 1234, got PC %x and BC %x", report.PC, report.BC)
+	}
+	if report.Memory[0] != 0x12 {
+		t.Errorf("Expected memory at PC to start %x, got %x", 0x12, report.Memory[0])
+	}
+}
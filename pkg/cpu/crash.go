@@ -0,0 +1,53 @@
+package cpu
+
+import "fmt"
+
+const crashReportBytes = 8
+
+// A CrashReport records the CPU state when emulation stopped because it
+// couldn't continue
+type CrashReport struct {
+	Reason                 string
+	PC, SP, AF, BC, DE, HL uint16
+	IME, Halt              bool
+	Cycles                 uint
+	// Memory holds the bytes from PC onwards
+	Memory [crashReportBytes]byte
+}
+
+func (r *CrashReport) Error() string {
+	return fmt.Sprintf("emulation stopped: %s\n"+
+		"PC=%04X SP=%04X AF=%04X BC=%04X DE=%04X HL=%04X IME=%t halt=%t cycles=%d\n"+
+		"memory at PC: % X",
+		r.Reason, r.PC, r.SP, r.AF, r.BC, r.DE, r.HL, r.IME, r.Halt, r.Cycles, r.Memory)
+}
+
+// crash returns a report of the current state to panic with. Frontends turn
+// the panic back into an error with ReportCrash.
+func (cpu *CPU) crash(reason string) *CrashReport {
+	report := &CrashReport{
+		Reason: reason,
+		PC:     cpu.GetPC(),
+		SP:     cpu.GetSP(),
+		AF:     cpu.GetAF(),
+		BC:     cpu.GetBC(),
+		DE:     cpu.GetDE(),
+		HL:     cpu.GetHL(),
+		IME:    cpu.IME,
+		Halt:   cpu.halt,
+		Cycles: cpu.GetCycles(),
+	}
+	for i := range report.Memory {
+		report.Memory[i] = cpu.memory.Get(report.PC + uint16(i))
+	}
+	return report
+}
+
+// ReportCrash returns a crash report for a value recovered from a panic while
+// the CPU was running
+func (cpu *CPU) ReportCrash(recovered interface{}) *CrashReport {
+	if report, ok := recovered.(*CrashReport); ok {
+		return report
+	}
+	return cpu.crash(fmt.Sprint(recovered))
+}
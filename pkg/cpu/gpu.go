@@ -10,19 +10,26 @@ import (
 const SpriteDataStartAddress = 0x8000
 
 type GPU struct {
-	cpu                *CPU
-	display            DisplayInterface
-	cyclesCounter      uint
-	vBlankCounter      uint
-	oams               []*oamEntry
-	bgPixelVisibility  [constants.ScreenWidth]pixelVisibility
-	interruptTriggered bool
-	vram               [0x2000]byte
-	sram               [0x100]byte
-}
-
+	cpu               *CPU
+	display           DisplayInterface
+	lineStart         uint64
+	scanline          byte
+	oams              []*oamEntry
+	bgPixelVisibility [constants.ScreenWidth]pixelVisibility
+	statLine          bool
+	lcdOff            bool
+	frames            uint
+	palette           [4]RGB
+	vram              [0x2000]byte
+	sram              [0x100]byte
+}
+
+// A display is told when the first line of a frame starts and when the last
+// line has been drawn, so it only ever presents complete frames
 type DisplayInterface interface {
+	StartFrame()
 	WritePixel(x, y, r, g, b byte)
+	EndFrame()
 }
 
 type RGB struct {
@@ -62,6 +69,7 @@ const (
 	CyclesPerSearchingOAMMode      = 80
 	CyclesPerTransferringMode      = 172
 	CyclesPerHBlankMode            = 204
+	ModeChangeDelay                = 4
 )
 
 const (
@@ -71,6 +79,8 @@ const (
 	SearchingOAMModeCycleBound      = 376
 	TransferringModeCycleBound      = 302
 	StatusModeResetMask             = 0xFC
+	StatusWritableMask              = 0x78
+	StatusReadOnlyMask              = 0x7
 	ModeMask                        = 3
 )
 
@@ -95,88 +105,181 @@ const (
 	SpriteXOffset              = 8
 )
 
-var standardPalette = []byte{0xff, 0xaa, 0x55, 0x00}
+// Shades of the standard palette
+const (
+	white     byte = 0xff
+	lightGrey      = 0xaa
+	darkGrey       = 0x55
+	black          = 0x00
+)
+
+// The display only needs to run on dots where its state can change
+var displayUpdateDots = []uint{
+	ModeChangeDelay,
+	CyclesPerSearchingOAMMode,
+	CyclesPerSearchingOAMMode + CyclesPerTransferringMode,
+	CyclesPerScanline - 1,
+	CyclesPerScanline,
+}
 
 func InitGPU(cpu *CPU) *GPU {
 	gpu := &GPU{
-		cpu:  cpu,
-		vram: [0x2000]byte{},
-		sram: [0x100]byte{},
+		cpu:       cpu,
+		vram:      [0x2000]byte{},
+		sram:      [0x100]byte{},
+		lineStart: cpu.scheduler.now + 1,
+		palette: [4]RGB{
+			{white, white, white},
+			{lightGrey, lightGrey, lightGrey},
+			{darkGrey, darkGrey, darkGrey},
+			{black, black, black},
+		},
 	}
 	gpu.setStatusMode(SearchingOAMMode)
+	gpu.scheduleUpdate(1)
 	return gpu
 }
 
-func (gpu *GPU) update() {
-	if gpu.cpu.stop {
-		return
-	}
-	if !gpu.getControl().isDisplayEnabled() {
-		return
+// dot returns the position in the current scanline of the next clock
+func (gpu *GPU) dot() uint {
+	if gpu.lcdOff {
+		return 0
 	}
+	return uint(gpu.cpu.scheduler.now + 1 - gpu.lineStart)
+}
 
-	currentLine := gpu.cpu.ReadIO(c.LYAddress)
-	currentMode := gpu.getStatus().mode()
+func (gpu *GPU) scheduleUpdate(cycles uint) {
+	gpu.cpu.scheduler.schedule(displayEvent, cycles)
+}
 
-	gpu.cyclesCounter++
+// The display is frozen in STOP mode
+func (gpu *GPU) pause(cycles uint) {
+	gpu.lineStart += uint64(cycles)
+	gpu.cpu.scheduler.postpone(displayEvent, cycles)
+}
 
-	switch gpu.cyclesCounter {
-	case 4:
-		if currentMode != VBlankMode {
-			gpu.setStatusMode(SearchingOAMMode)
-			gpu.handleInterrupts()
+func (gpu *GPU) update() {
+	if !gpu.getControl().isDisplayEnabled() {
+		if !gpu.lcdOff {
+			gpu.disableLCD()
 		}
-	case 80:
-		if currentMode == SearchingOAMMode {
-			gpu.parseOAMForScanline(currentLine)
+		return
+	}
+	if gpu.lcdOff {
+		// The first line after the LCD is turned on is four dots short
+		gpu.lcdOff = false
+		gpu.lineStart = gpu.cpu.scheduler.now - ModeChangeDelay
+	}
+	dot := uint(gpu.cpu.scheduler.now - gpu.lineStart)
+
+	switch {
+	case gpu.scanline < VBlankStartScanline:
+		switch dot {
+		case ModeChangeDelay:
+			if gpu.scanline == 0 {
+				gpu.display.StartFrame()
+			}
+			gpu.setStatusMode(SearchingOAMMode)
+		case CyclesPerSearchingOAMMode:
+			gpu.parseOAMForScanline(gpu.scanline)
 			gpu.setStatusMode(TransferringMode)
-		}
-	case 252:
-		if currentMode == TransferringMode {
+		case CyclesPerSearchingOAMMode + CyclesPerTransferringMode:
 			gpu.setStatusMode(HBlankMode)
-			gpu.renderScanline(currentLine)
-			gpu.handleInterrupts()
+			gpu.renderScanline(gpu.scanline)
 		}
-	case CyclesPerScanline:
-		newScanline := gpu.incrementScanline()
-		gpu.cyclesCounter = 0
-
-		if newScanline == VBlankStartScanline && currentMode != VBlankMode {
-			gpu.setStatusMode(VBlankMode)
-			gpu.requestInterrupt(VBlank)
-			gpu.handleInterrupts()
+	case gpu.scanline == VBlankStartScanline && dot == ModeChangeDelay:
+		gpu.setStatusMode(VBlankMode)
+		gpu.requestInterrupt(VBlank)
+		gpu.frames++
+		gpu.display.EndFrame()
+	case gpu.scanline == MaxScanline && dot == ModeChangeDelay:
+		// LY reads 0 for almost all of the last line
+		gpu.cpu.WriteIO(c.LYAddress, 0)
+	}
+
+	gpu.updateMatchFlag(dot)
+	gpu.updateStatInterruptLine(dot)
+
+	if dot == CyclesPerScanline-1 {
+		gpu.lineStart += uint64(CyclesPerScanline)
+		gpu.incrementScanline()
+	}
+	for _, next := range displayUpdateDots {
+		if next > dot {
+			gpu.scheduleUpdate(next - dot)
+			break
 		}
 	}
+}
 
-	if gpu.getStatus().mode() == VBlankMode {
-		gpu.vBlankCounter++
-		if gpu.vBlankCounter == CyclesPerScanline*ScanlinesPerVBlank {
-			gpu.setStatusMode(HBlankMode)
-			gpu.resetScanline()
-			gpu.cyclesCounter = 0
-			gpu.vBlankCounter = 0
+// The STAT interrupt sources are ORed onto a single line and the interrupt is
+// requested only on its rising edge, so one source holding the line high
+// blocks the others from triggering.
+func (gpu *GPU) updateStatInterruptLine(dot uint) {
+	line := gpu.statInterruptLine(gpu.getStatus(), dot)
+	if line && !gpu.statLine {
+		gpu.requestInterrupt(LCDCStatus)
+	}
+	gpu.statLine = line
+}
+
+func (gpu *GPU) statInterruptLine(status GPUStatus, dot uint) bool {
+	if status.isSet(MatchFlag) && status.isSet(MatchInterrupt) {
+		return true
+	}
+	switch status.mode() {
+	case HBlankMode:
+		if status.isSet(HBlankInterrupt) {
+			return true
+		}
+	case VBlankMode:
+		if status.isSet(VBlankInterrupt) {
+			return true
+		}
+	case SearchingOAMMode:
+		if status.isSet(OAMInterrupt) {
+			return true
 		}
-		gpu.handleInterrupts()
 	}
+	// The OAM source is also raised as line 144 begins
+	return gpu.scanline == VBlankStartScanline && dot < ModeChangeDelay && status.isSet(OAMInterrupt)
+}
 
-	if gpu.cpu.ReadIO(c.LYAddress) == gpu.cpu.ReadIO(c.LYCAddress) {
+func (gpu *GPU) updateMatchFlag(dot uint) {
+	// LY=LYC reads false briefly while LY changes at the start of a line
+	lyChanging := gpu.scanline > 0 && gpu.scanline < MaxScanline && dot < ModeChangeDelay
+	if !lyChanging && gpu.cpu.ReadIO(c.LYAddress) == gpu.cpu.ReadIO(c.LYCAddress) {
 		gpu.setMatchFlag()
 	} else {
 		gpu.resetMatchFlag()
 	}
 }
 
-func (gpu *GPU) handleInterrupts() {
-	currentMode := gpu.getStatus().mode()
-
-	if gpu.isModeInterruptSet(currentMode) || (gpu.isStatusSet(MatchFlag) && gpu.isStatusSet(MatchInterrupt)) {
-		if !gpu.interruptTriggered {
-			gpu.interruptTriggered = true
-			gpu.cpu.requestInterrupt(LCDCStatus)
-			return
+// On DMG a STAT write behaves as if 0xFF were written for one cycle before
+// the real value, which can trigger a spurious interrupt in modes 0 and 1 or
+// when LY=LYC.
+func (gpu *GPU) writeStatus(value byte) {
+	status := gpu.getStatus()
+	if gpu.cpu.model == DMG && gpu.getControl().isDisplayEnabled() && status.mode() != TransferringMode {
+		bugged := status | GPUStatus(HBlankInterrupt|VBlankInterrupt|MatchInterrupt)
+		if status.mode() == SearchingOAMMode {
+			bugged = status | GPUStatus(MatchInterrupt)
+		}
+		if gpu.statInterruptLine(bugged, gpu.dot()) && !gpu.statLine {
+			gpu.requestInterrupt(LCDCStatus)
+			gpu.statLine = true
 		}
 	}
-	gpu.interruptTriggered = false
+	gpu.setStatus(GPUStatus((value & StatusWritableMask) | (byte(status) & StatusReadOnlyMask)))
+}
+
+func (gpu *GPU) disableLCD() {
+	gpu.lcdOff = true
+	gpu.scanline = 0
+	gpu.statLine = false
+	gpu.cpu.WriteIO(c.LYAddress, 0)
+	gpu.resetMatchFlag()
+	gpu.setStatusMode(HBlankMode)
 }
 
 func (gpu *GPU) renderScanline(scanline byte) {
@@ -187,6 +290,8 @@ func (gpu *GPU) renderScanline(scanline byte) {
 
 	if control.isBGEnabled() {
 		gpu.renderBackground(scanline)
+	} else {
+		gpu.clearScanline(scanline)
 	}
 
 	if control.isWindowEnabled() && scanline >= gpu.cpu.ReadIO(c.WindowYAddress) {
@@ -198,15 +303,22 @@ func (gpu *GPU) renderScanline(scanline byte) {
 	}
 }
 
-func (gpu *GPU) resetScanline() {
-	gpu.cpu.WriteIO(c.LYAddress, 0)
+// With the background disabled the line shows the lightest shade under any
+// window or sprites
+func (gpu *GPU) clearScanline(scanline byte) {
+	rgb := gpu.palette[0]
+	for x := 0; x < constants.ScreenWidth; x++ {
+		gpu.display.WritePixel(byte(x), scanline, rgb.r, rgb.g, rgb.b)
+	}
 }
 
-func (gpu *GPU) incrementScanline() byte {
-	currentScanline := gpu.cpu.ReadIO(c.LYAddress)
-	currentScanline++
-	gpu.cpu.WriteIO(c.LYAddress, currentScanline)
-	return currentScanline
+func (gpu *GPU) incrementScanline() {
+	gpu.scanline++
+	if gpu.scanline > MaxScanline {
+		gpu.scanline = 0
+		return
+	}
+	gpu.cpu.WriteIO(c.LYAddress, gpu.scanline)
 }
 
 func (gpu *GPU) requestInterrupt(interrupt Interrupt) {
@@ -234,21 +346,24 @@ func (gpu *GPU) renderBackground(scanline byte) {
 	}
 }
 
+// oams must be in priority order. The first opaque sprite pixel wins and only
+// then is its BG priority flag checked, so a lower priority sprite never shows
+// through a higher priority one that is hidden behind the background.
 func (gpu *GPU) renderSprites(oams []*oamEntry, scanline byte) {
-	for _, e := range oams {
-		var startX byte = 0
-		if e.x > 0 {
-			startX = byte(e.x)
-		}
-		endX := byte(e.x + SpritePixelSize)
-
-		for x := startX; x < endX && x < byte(constants.ScreenWidth); x++ {
-			if e.behindBG() && gpu.bgPixelVisibility[x] == visible {
+	for x := 0; x < constants.ScreenWidth; x++ {
+		for _, e := range oams {
+			if !e.coversX(x) {
+				continue
+			}
+			colour := gpu.fetchSpriteColour(e, byte(x), scanline)
+			if colour == 0 {
 				continue
 			}
-			if rgb, isVisible := gpu.fetchSpritePixel(e, x, scanline); isVisible {
-				gpu.display.WritePixel(x, scanline, rgb.r, rgb.r, rgb.b)
+			if !e.behindBG() || gpu.bgPixelVisibility[x] == invisible {
+				rgb := gpu.applySpritePalette(colour, e)
+				gpu.display.WritePixel(byte(x), scanline, rgb.r, rgb.g, rgb.b)
 			}
+			break
 		}
 	}
 }
@@ -325,7 +440,7 @@ func (gpu *GPU) fetchCharCodeBytes(baseAddress, tileOffset uint16) (byte, byte)
 	return low, high
 }
 
-func (gpu *GPU) fetchSpritePixel(e *oamEntry, x, y byte) (RGB, pixelVisibility) {
+func (gpu *GPU) fetchSpriteColour(e *oamEntry, x, y byte) colourCode {
 	tileX := byte(int16(x) - e.x)
 	tileY := byte(int16(y) - e.y)
 
@@ -346,13 +461,7 @@ func (gpu *GPU) fetchSpritePixel(e *oamEntry, x, y byte) (RGB, pixelVisibility)
 	charCode := uint16(tileY & CharCodeMask)
 	spriteAddress := getSpriteAddress(tile)
 	low, high := gpu.fetchSpriteData(spriteAddress, charCode)
-	colour := getColourCodeFrom(tileX, low, high)
-	if colour == 0 {
-		return RGB{0, 0, 0}, invisible
-	}
-
-	rgb := gpu.applySpritePalette(colour, e)
-	return rgb, visible
+	return getColourCodeFrom(tileX, low, high)
 }
 
 func ignoreLowerBit(val byte) byte {
@@ -379,7 +488,7 @@ func getColourCodeFrom(xPos, low, high byte) colourCode {
 
 func (gpu *GPU) applyBGPalette(colour colourCode) RGB {
 	paletteRegister := gpu.cpu.ReadIO(c.BGPAddress)
-	return applyPalette(selectColourCode(paletteRegister, colour))
+	return gpu.applyPalette(selectColourCode(paletteRegister, colour))
 }
 
 func (gpu *GPU) applySpritePalette(colour colourCode, e *oamEntry) RGB {
@@ -387,19 +496,19 @@ func (gpu *GPU) applySpritePalette(colour colourCode, e *oamEntry) RGB {
 	if e.useOBP1() {
 		paletteRegister = gpu.cpu.ReadIO(c.OBP1Address)
 	}
-	return applyPalette(selectColourCode(paletteRegister, colour))
+	return gpu.applyPalette(selectColourCode(paletteRegister, colour))
 }
 
 func selectColourCode(register byte, colour colourCode) colourCode {
 	return colourCode((register >> (colour * BitsPerColour)) & ColourMask)
 }
 
-func applyPalette(code colourCode) RGB {
-	colour := standardPalette[code]
-	return RGB{r: colour, g: colour, b: colour}
+func (gpu *GPU) applyPalette(code colourCode) RGB {
+	return gpu.palette[code]
 }
 
 type oamEntry struct {
+	index   int
 	x       int16
 	y       int16
 	height  byte
@@ -412,6 +521,10 @@ func (e *oamEntry) yFlip() bool    { return e.flags&0x40 != 0 }
 func (e *oamEntry) xFlip() bool    { return e.flags&0x20 != 0 }
 func (e *oamEntry) useOBP1() bool  { return e.flags&0x10 != 0 }
 
+func (e *oamEntry) coversX(x int) bool {
+	return x >= int(e.x) && x < int(e.x)+SpritePixelSize
+}
+
 func yInSprite(scanline byte, y int16, height byte) bool {
 	return int16(scanline) >= y && int16(scanline) < y+int16(height)
 }
@@ -423,6 +536,7 @@ func (gpu *GPU) parseOAMForScanline(scanline byte) {
 		height = 16
 	}
 	gpu.oams = gpu.oams[:0]
+	// Off-screen sprites still count towards the limit
 	for i := 0; len(gpu.oams) < MaxSpritesPerScanline && i < MaxSpritesPerScreen; i++ {
 		offset := uint16(i * SpriteByteSize)
 		y, x, num, flags := gpu.fetchOAMData(offset)
@@ -430,6 +544,7 @@ func (gpu *GPU) parseOAMForScanline(scanline byte) {
 			continue
 		}
 		gpu.oams = append(gpu.oams, &oamEntry{
+			index:   i,
 			x:       x,
 			y:       y,
 			height:  height,
@@ -438,7 +553,7 @@ func (gpu *GPU) parseOAMForScanline(scanline byte) {
 		})
 	}
 
-	sort.Stable(sortableOAM(gpu.oams))
+	sort.Sort(sortableOAM(gpu.oams))
 }
 
 func (gpu *GPU) fetchOAMData(location uint16) (int16, int16, tileNum, flags) {
@@ -448,9 +563,14 @@ func (gpu *GPU) fetchOAMData(location uint16) (int16, int16, tileNum, flags) {
 
 type sortableOAM []*oamEntry
 
-func (s sortableOAM) Less(i, j int) bool { return s[i].x < s[j].x }
-func (s sortableOAM) Len() int           { return len(s) }
-func (s sortableOAM) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s sortableOAM) Less(i, j int) bool {
+	if s[i].x == s[j].x {
+		return s[i].index < s[j].index
+	}
+	return s[i].x < s[j].x
+}
+func (s sortableOAM) Len() int      { return len(s) }
+func (s sortableOAM) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
 
 func (gpu *GPU) writeOAM(addr uint16, val byte) {
 	currentMode := gpu.getStatus().mode()
@@ -459,10 +579,15 @@ func (gpu *GPU) writeOAM(addr uint16, val byte) {
 	}
 }
 
+// DMA writes ignore PPU mode restrictions
+func (gpu *GPU) writeOAMDMA(addr uint16, val byte) {
+	gpu.sram[addr-0xFE00] = val
+}
+
 func (gpu *GPU) readOAM(addr uint16) byte {
 	currentMode := gpu.getStatus().mode()
 	if !(currentMode == SearchingOAMMode || currentMode == TransferringMode) {
-		return gpu.sram[addr]
+		return gpu.sram[addr-0xFE00]
 	}
 	return 0xff
 }
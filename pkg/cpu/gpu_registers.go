@@ -2,7 +2,6 @@ package cpu
 
 import (
 	c "github.com/tbtommyb/goboy/pkg/constants"
-	"github.com/tbtommyb/goboy/pkg/utils"
 )
 
 type GPUStatus byte
@@ -22,11 +21,11 @@ const (
 )
 
 const (
-	MatchFlag              GPUStatusFlag = 2
-	AccessEnabledInterrupt               = 3
-	VBlankInterrupt                      = 4
-	OAMInterrupt                         = 5
-	MatchInterrupt                       = 6
+	MatchFlag       GPUStatusFlag = 0x4
+	HBlankInterrupt               = 0x8
+	VBlankInterrupt               = 0x10
+	OAMInterrupt                  = 0x20
+	MatchInterrupt                = 0x40
 )
 
 // LCD Control
@@ -35,13 +34,15 @@ func (gpu *GPU) getControl() GPUControl {
 	return GPUControl(gpu.cpu.ReadIO(c.LCDCAddress))
 }
 
-func (gpu *GPU) setControl(control GPUControl) {
+// The display picks up LCDC and LYC writes on the next clock
+func (gpu *GPU) writeControl(control GPUControl) {
 	gpu.cpu.WriteIO(c.LCDCAddress, byte(control))
-	if !control.isDisplayEnabled() {
-		gpu.cpu.WriteIO(c.LYAddress, 0)
-		gpu.resetMatchFlag()
-		gpu.setStatusMode(HBlankMode)
-	}
+	gpu.scheduleUpdate(1)
+}
+
+func (gpu *GPU) writeLYCompare(value byte) {
+	gpu.cpu.WriteIO(c.LYCAddress, value)
+	gpu.scheduleUpdate(1)
 }
 
 func (control GPUControl) isDisplayEnabled() bool {
@@ -99,33 +100,14 @@ func (gpu *GPU) setStatusMode(mode Mode) {
 	gpu.setStatus(GPUStatus((byte(status) & StatusModeResetMask) | byte(mode)))
 }
 
-func (gpu *GPU) isModeInterruptSet(mode Mode) bool {
-	if mode == TransferringMode {
-		return false
-	}
-	status := gpu.getStatus()
-	if mode == VBlankMode {
-		return utils.IsSet(byte(VBlankInterrupt), byte(status)) || utils.IsSet(byte(OAMInterrupt), byte(status))
-	}
-	return utils.IsSet(byte(mode)+3, byte(status))
+func (status GPUStatus) isSet(flag GPUStatusFlag) bool {
+	return (byte(status) & byte(flag)) > 0
 }
 
 func (gpu *GPU) setMatchFlag() {
-	status := gpu.getStatus()
-	gpu.setStatus(GPUStatus(utils.SetBit(byte(MatchFlag), byte(status), 1)))
+	gpu.setStatus(gpu.getStatus() | GPUStatus(MatchFlag))
 }
 
 func (gpu *GPU) resetMatchFlag() {
-	status := gpu.getStatus()
-	gpu.setStatus(GPUStatus(utils.SetBit(byte(MatchFlag), byte(status), 0)))
-}
-
-func (gpu *GPU) isMatchInterruptSet() bool {
-	status := gpu.getStatus()
-	return utils.IsSet(byte(MatchInterrupt), byte(status))
-}
-
-func (gpu *GPU) isStatusSet(flag GPUStatusFlag) bool {
-	status := gpu.getStatus()
-	return utils.IsSet(byte(flag), byte(status))
+	gpu.setStatus(gpu.getStatus() &^ GPUStatus(MatchFlag))
 }
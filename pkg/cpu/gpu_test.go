@@ -0,0 +1,423 @@
+package cpu
+
+import (
+	"testing"
+
+	c "github.com/tbtommyb/goboy/pkg/constants"
+)
+
+type TestDisplay struct {
+	pixels      [c.ScreenWidth][c.ScreenHeight]RGB
+	frameActive bool
+	lastLine    byte
+	frames      int
+}
+
+func (d *TestDisplay) StartFrame() {
+	d.frameActive = true
+}
+
+func (d *TestDisplay) WritePixel(x, y, r, g, b byte) {
+	d.pixels[x][y] = RGB{r, g, b}
+	d.lastLine = y
+}
+
+func (d *TestDisplay) EndFrame() {
+	if d.frameActive && d.lastLine == c.ScreenHeight-1 {
+		d.frames++
+	}
+	d.frameActive = false
+}
+
+type testSprite struct {
+	y, x, tile, flags byte
+}
+
+func createGPU(sprites []testSprite) (*GPU, *TestDisplay) {
+	gpu := createCPU().gpu
+	d := &TestDisplay{}
+	gpu.display = d
+	gpu.cpu.WriteIO(c.BGPAddress, 0xE4)
+	gpu.cpu.WriteIO(c.OBP0Address, 0xE4)
+	gpu.cpu.WriteIO(c.OBP1Address, 0xE4)
+	for i, s := range sprites {
+		copy(gpu.sram[i*SpriteByteSize:], []byte{s.y, s.x, s.tile, s.flags})
+	}
+	return gpu, d
+}
+
+// fillTile sets every pixel of a tile in 0x8000 addressing to colour
+func fillTile(gpu *GPU, tile byte, colour colourCode) {
+	var low, high byte
+	if colour&1 > 0 {
+		low = 0xFF
+	}
+	if colour&2 > 0 {
+		high = 0xFF
+	}
+	for row := 0; row < TilePixelSize; row++ {
+		gpu.vram[int(tile)*CharCodeSize+row*2] = low
+		gpu.vram[int(tile)*CharCodeSize+row*2+1] = high
+	}
+}
+
+func renderLine(gpu *GPU, scanline byte) {
+	gpu.parseOAMForScanline(scanline)
+	gpu.renderScanline(scanline)
+}
+
+func expectPixel(t *testing.T, d *TestDisplay, name string, x, y int, expected byte) {
+	expectColour(t, d, name, x, y, RGB{expected, expected, expected})
+}
+
+func expectColour(t *testing.T, d *TestDisplay, name string, x, y int, expected RGB) {
+	if actual := d.pixels[x][y]; actual != expected {
+		t.Errorf("%s: expected pixel (%d, %d) to be %v, got %v", name, x, y, expected, actual)
+	}
+}
+
+func TestOverlappingSpritePriority(t *testing.T) {
+	testCases := []struct {
+		sprites  []testSprite
+		x        int
+		expected byte
+		message  string
+	}{
+		{
+			sprites:  []testSprite{{y: 16, x: 28, tile: 1}, {y: 16, x: 24, tile: 3}},
+			x:        21,
+			expected: black,
+			message:  "lower X wins",
+		},
+		{
+			sprites:  []testSprite{{y: 16, x: 24, tile: 1}, {y: 16, x: 24, tile: 3}},
+			x:        18,
+			expected: lightGrey,
+			message:  "lower OAM index wins on equal X",
+		},
+		{
+			sprites:  []testSprite{{y: 16, x: 24, tile: 0}, {y: 16, x: 24, tile: 3}},
+			x:        18,
+			expected: black,
+			message:  "transparent pixel falls through",
+		},
+		{
+			sprites:  []testSprite{{y: 16, x: 24, tile: 1, flags: 0x10}},
+			x:        18,
+			expected: white,
+			message:  "OBP1 is used",
+		},
+	}
+
+	for _, test := range testCases {
+		gpu, d := createGPU(test.sprites)
+		gpu.cpu.WriteIO(c.LCDCAddress, byte(LCDDisplayEnable|SpriteEnable|BGEnable|DataSelect))
+		gpu.cpu.WriteIO(c.OBP1Address, 0x00)
+		fillTile(gpu, 1, 1)
+		fillTile(gpu, 3, 3)
+
+		renderLine(gpu, 0)
+
+		expectPixel(t, d, test.message, test.x, 0, test.expected)
+	}
+}
+
+func TestSpriteBehindBackground(t *testing.T) {
+	sprites := []testSprite{
+		{y: 16, x: 8, tile: 1, flags: 0x80},
+		{y: 16, x: 10, tile: 3},
+	}
+	gpu, d := createGPU(sprites)
+	gpu.cpu.WriteIO(c.LCDCAddress, byte(LCDDisplayEnable|SpriteEnable|BGEnable|DataSelect))
+	fillTile(gpu, 1, 1)
+	fillTile(gpu, 3, 3)
+	// Background tile 2 is colour 2 on its left half and colour 0 on its right
+	for row := 0; row < TilePixelSize; row++ {
+		gpu.vram[2*CharCodeSize+row*2+1] = 0xF0
+	}
+	gpu.vram[0x1800] = 2
+
+	renderLine(gpu, 0)
+
+	expectPixel(t, d, "behind BG colour 2", 1, 0, darkGrey)
+	expectPixel(t, d, "behind BG colour 0", 5, 0, lightGrey)
+	expectPixel(t, d, "hidden winner masks lower priority sprite", 3, 0, darkGrey)
+	expectPixel(t, d, "lower priority sprite outside winner", 9, 0, black)
+}
+
+func TestTallSprites(t *testing.T) {
+	testCases := []struct {
+		flags    byte
+		scanline byte
+		expected byte
+		message  string
+	}{
+		{flags: 0, scanline: 2, expected: lightGrey, message: "top half"},
+		{flags: 0, scanline: 12, expected: black, message: "bottom half"},
+		{flags: 0x40, scanline: 2, expected: black, message: "flipped top half"},
+		{flags: 0x40, scanline: 12, expected: lightGrey, message: "flipped bottom half"},
+	}
+
+	for _, test := range testCases {
+		// The lower bit of the tile number is ignored in 8x16 mode
+		gpu, d := createGPU([]testSprite{{y: 16, x: 8, tile: 5, flags: test.flags}})
+		gpu.cpu.WriteIO(c.LCDCAddress, byte(LCDDisplayEnable|SpriteEnable|SpriteSize|BGEnable|DataSelect))
+		fillTile(gpu, 4, 1)
+		fillTile(gpu, 5, 3)
+
+		renderLine(gpu, test.scanline)
+
+		expectPixel(t, d, test.message, 0, int(test.scanline), test.expected)
+	}
+}
+
+func TestOffscreenSpritesCountTowardsLimit(t *testing.T) {
+	var sprites []testSprite
+	for i := 0; i < MaxSpritesPerScanline; i++ {
+		sprites = append(sprites, testSprite{y: 16, x: 0, tile: 1})
+	}
+	sprites = append(sprites, testSprite{y: 16, x: 8, tile: 1})
+	gpu, d := createGPU(sprites)
+	gpu.cpu.WriteIO(c.LCDCAddress, byte(LCDDisplayEnable|SpriteEnable|BGEnable|DataSelect))
+	fillTile(gpu, 1, 1)
+
+	renderLine(gpu, 0)
+
+	if actual := len(gpu.oams); actual != MaxSpritesPerScanline {
+		t.Errorf("Expected %d sprites on scanline, got %d", MaxSpritesPerScanline, actual)
+	}
+	expectPixel(t, d, "eleventh sprite", 0, 0, white)
+}
+
+func TestPartiallyOffscreenSprite(t *testing.T) {
+	gpu, d := createGPU([]testSprite{{y: 16, x: 4, tile: 1}, {y: 16, x: 164, tile: 3}})
+	gpu.cpu.WriteIO(c.LCDCAddress, byte(LCDDisplayEnable|SpriteEnable|BGEnable|DataSelect))
+	fillTile(gpu, 1, 1)
+	fillTile(gpu, 3, 3)
+
+	renderLine(gpu, 0)
+
+	expectPixel(t, d, "left edge", 0, 0, lightGrey)
+	expectPixel(t, d, "left edge end", 3, 0, lightGrey)
+	expectPixel(t, d, "left edge clipped", 4, 0, white)
+	expectPixel(t, d, "right edge", 159, 0, black)
+	expectPixel(t, d, "right edge start", 155, 0, white)
+}
+
+func TestSpriteColourChannels(t *testing.T) {
+	gpu, d := createGPU([]testSprite{{y: 16, x: 8, tile: 1}})
+	gpu.cpu.WriteIO(c.LCDCAddress, byte(LCDDisplayEnable|SpriteEnable|BGEnable|DataSelect))
+	gpu.palette = [4]RGB{{0xE0, 0xF8, 0xD0}, {0x88, 0xC0, 0x70}, {0x34, 0x68, 0x56}, {0x08, 0x18, 0x20}}
+	fillTile(gpu, 1, 1)
+
+	renderLine(gpu, 0)
+
+	expectColour(t, d, "sprite", 0, 0, RGB{0x88, 0xC0, 0x70})
+	expectColour(t, d, "background", 8, 0, RGB{0xE0, 0xF8, 0xD0})
+}
+
+// runDots steps the GPU and returns the number of LCDC status interrupts requested
+func runDots(gpu *GPU, dots int) int {
+	requests := 0
+	for i := 0; i < dots; i++ {
+		gpu.cpu.RunFor(1)
+		if gpu.cpu.memory.Get(c.InterruptFlagAddress)&(1<<LCDCStatus) > 0 {
+			requests++
+			gpu.cpu.clearInterrupt(LCDCStatus)
+		}
+	}
+	return requests
+}
+
+func TestStatInterruptLine(t *testing.T) {
+	frame := int(CyclesPerScanline) * (int(MaxScanline) + 1)
+	testCases := []struct {
+		status   byte
+		lyc      byte
+		expected int
+		message  string
+	}{
+		{status: HBlankInterrupt, lyc: 0xFF, expected: 144, message: "HBlank"},
+		{status: VBlankInterrupt, lyc: 0xFF, expected: 1, message: "VBlank"},
+		{status: OAMInterrupt, lyc: 0xFF, expected: 145, message: "OAM and line 144"},
+		{status: MatchInterrupt, lyc: 20, expected: 1, message: "LYC"},
+		{status: HBlankInterrupt | MatchInterrupt, lyc: 20, expected: 143, message: "LYC blocked by HBlank"},
+		{status: HBlankInterrupt | VBlankInterrupt, lyc: 0xFF, expected: 144, message: "VBlank blocked by HBlank"},
+	}
+
+	for _, test := range testCases {
+		gpu, _ := createGPU(nil)
+		gpu.cpu.WriteIO(c.LCDCAddress, byte(LCDDisplayEnable))
+		gpu.cpu.WriteIO(c.LYCAddress, test.lyc)
+		gpu.setStatus(GPUStatus(test.status) | GPUStatus(SearchingOAMMode))
+
+		if actual := runDots(gpu, frame); actual != test.expected {
+			t.Errorf("%s: expected %d interrupts, got %d", test.message, test.expected, actual)
+		}
+	}
+}
+
+func TestLastScanlineReadsZero(t *testing.T) {
+	gpu, _ := createGPU(nil)
+	gpu.cpu.WriteIO(c.LCDCAddress, byte(LCDDisplayEnable))
+	gpu.cpu.WriteIO(c.LYCAddress, 0)
+	gpu.setStatus(GPUStatus(MatchInterrupt))
+
+	runDots(gpu, int(CyclesPerScanline)*int(MaxScanline))
+	if actual := gpu.cpu.ReadIO(c.LYAddress); actual != MaxScanline {
+		t.Errorf("Expected LY to be %d at start of last line, got %d", MaxScanline, actual)
+	}
+
+	if actual := runDots(gpu, ModeChangeDelay+1); actual != 1 {
+		t.Errorf("Expected LYC=0 interrupt early in last line, got %d", actual)
+	}
+	if actual := gpu.cpu.ReadIO(c.LYAddress); actual != 0 {
+		t.Errorf("Expected LY to read 0 during last line, got %d", actual)
+	}
+	if actual := runDots(gpu, int(CyclesPerScanline)); actual != 0 {
+		t.Errorf("Expected no further LYC=0 interrupt on line 0, got %d", actual)
+	}
+}
+
+func TestStatWriteBug(t *testing.T) {
+	testCases := []struct {
+		dots     int
+		model    Model
+		expected bool
+		message  string
+	}{
+		{dots: 300, expected: true, message: "HBlank"},
+		{dots: int(CyclesPerScanline) * 150, expected: true, message: "VBlank"},
+		{dots: 100, expected: false, message: "Transferring"},
+		{dots: 40, expected: false, message: "Searching OAM"},
+		{dots: 300, model: CGB, expected: false, message: "CGB"},
+	}
+
+	for _, test := range testCases {
+		gpu, _ := createGPU(nil)
+		gpu.cpu.model = test.model
+		gpu.cpu.WriteIO(c.LCDCAddress, byte(LCDDisplayEnable))
+		gpu.cpu.WriteIO(c.LYCAddress, 0xFF)
+		runDots(gpu, test.dots)
+
+		gpu.writeStatus(0)
+
+		requested := gpu.cpu.memory.Get(c.InterruptFlagAddress)&(1<<LCDCStatus) > 0
+		if requested != test.expected {
+			t.Errorf("%s: expected STAT write interrupt to be %t", test.message, test.expected)
+		}
+		gpu.cpu.clearInterrupt(LCDCStatus)
+		if actual := runDots(gpu, 1); actual != 0 {
+			t.Errorf("%s: expected line to fall after STAT write, got %d interrupts", test.message, actual)
+		}
+	}
+}
+
+// createOAMBugGPU fills each OAM word with its own index and positions the
+// PPU on row of its OAM search
+func createOAMBugGPU(row int) *GPU {
+	gpu, _ := createGPU(nil)
+	gpu.cpu.WriteIO(c.LCDCAddress, byte(LCDDisplayEnable))
+	for i := 0; i < len(gpu.sram)/2; i++ {
+		gpu.setOAMWord(0, i, uint16(i))
+	}
+	gpu.lineStart = gpu.cpu.scheduler.now + 1 - uint64(row*CyclesPerOAMRow)
+	return gpu
+}
+
+func expectOAMRow(t *testing.T, gpu *GPU, name string, row int, expected [4]uint16) {
+	for i, word := range expected {
+		if actual := gpu.oamWord(row, i); actual != word {
+			t.Errorf("%s: expected row %d word %d to be %x, got %x", name, row, i, word, actual)
+		}
+	}
+}
+
+func TestOAMBugWriteCorruption(t *testing.T) {
+	gpu := createOAMBugGPU(5)
+	gpu.setOAMWord(5, 0, 0x00F0)
+	gpu.setOAMWord(4, 0, 0x0F00)
+	gpu.setOAMWord(4, 2, 0x3C3C)
+	gpu.triggerOAMWriteBug()
+
+	expectOAMRow(t, gpu, "write", 5, [4]uint16{0x0C30, 17, 0x3C3C, 19})
+	expectOAMRow(t, gpu, "write", 4, [4]uint16{0x0F00, 17, 0x3C3C, 19})
+}
+
+func TestOAMBugReadCorruption(t *testing.T) {
+	gpu := createOAMBugGPU(5)
+	gpu.setOAMWord(5, 0, 0x00F0)
+	gpu.setOAMWord(4, 0, 0x0F00)
+	gpu.setOAMWord(4, 2, 0x3C3C)
+	gpu.triggerOAMReadBug()
+
+	expectOAMRow(t, gpu, "read", 5, [4]uint16{0x0F30, 17, 0x3C3C, 19})
+}
+
+func TestOAMBugReadIncreaseCorruption(t *testing.T) {
+	gpu := createOAMBugGPU(5)
+	gpu.setOAMWord(3, 0, 0x00FF)
+	gpu.setOAMWord(4, 0, 0x0F0F)
+	gpu.setOAMWord(5, 0, 0x3333)
+	gpu.setOAMWord(4, 2, 0x5555)
+	gpu.triggerOAMReadIncreaseBug()
+
+	expected := [4]uint16{0x071F, 17, 0x5555, 19}
+	expectOAMRow(t, gpu, "read increase", 3, expected)
+	expectOAMRow(t, gpu, "read increase", 4, expected)
+	expectOAMRow(t, gpu, "read increase", 5, expected)
+	expectOAMRow(t, gpu, "read increase", 6, [4]uint16{24, 25, 26, 27})
+}
+
+func TestOAMBugNotTriggered(t *testing.T) {
+	testCases := []struct {
+		row     int
+		model   Model
+		message string
+	}{
+		{row: 0, model: DMG, message: "first row"},
+		{row: OAMRowCount, model: DMG, message: "transferring"},
+		{row: 5, model: CGB, message: "CGB"},
+	}
+
+	for _, test := range testCases {
+		gpu := createOAMBugGPU(test.row)
+		gpu.cpu.model = test.model
+		gpu.triggerOAMWriteBug()
+		gpu.triggerOAMReadIncreaseBug()
+
+		for row := 0; row < OAMRowCount; row++ {
+			w := uint16(row * 4)
+			expectOAMRow(t, gpu, test.message, row, [4]uint16{w, w + 1, w + 2, w + 3})
+		}
+	}
+}
+
+func TestLCDEnableShortensFirstLine(t *testing.T) {
+	gpu, _ := createGPU(nil)
+	runDots(gpu, 1)
+	gpu.writeControl(GPUControl(LCDDisplayEnable))
+
+	runDots(gpu, int(CyclesPerScanline-ModeChangeDelay)-1)
+	if actual := gpu.cpu.ReadIO(c.LYAddress); actual != 0 {
+		t.Errorf("Expected LY to be 0 before first line ends, got %d", actual)
+	}
+	runDots(gpu, 1)
+	if actual := gpu.cpu.ReadIO(c.LYAddress); actual != 1 {
+		t.Errorf("Expected LY to be 1 after first line, got %d", actual)
+	}
+}
+
+func TestFrameNotifications(t *testing.T) {
+	frame := int(CyclesPerScanline) * (int(MaxScanline) + 1)
+	gpu, d := createGPU(nil)
+	gpu.writeControl(GPUControl(LCDDisplayEnable))
+
+	runDots(gpu, 2*frame)
+	if d.frames != 2 {
+		t.Errorf("Expected 2 complete frames, got %d", d.frames)
+	}
+	if gpu.frames != 2 {
+		t.Errorf("Expected frame count 2, got %d", gpu.frames)
+	}
+}
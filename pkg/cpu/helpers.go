@@ -37,7 +37,7 @@ func (cpu *CPU) conditionMet(cond conditions.Condition) bool {
 			return true
 		}
 	default:
-		panic(fmt.Sprintf("Invalid condition %v", cond))
+		panic(cpu.crash(fmt.Sprintf("invalid condition %v", cond)))
 	}
 	return false
 }
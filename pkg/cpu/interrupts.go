@@ -23,33 +23,35 @@ const (
 	InputInterruptHandlerAddress                = 0x60
 )
 
-var Interrupts = []Interrupt{VBlank, LCDCStatus, TimerOverflow, Input}
+const InterruptMask byte = 0x1F
+
+// In priority order
+var Interrupts = []Interrupt{VBlank, LCDCStatus, TimerOverflow, Serial, Input}
 
 func (cpu *CPU) HandleInterrupts() {
-	requested := cpu.memory.Get(c.InterruptFlagAddress)
-	if requested == 0 {
-		return
-	}
-	enabled := cpu.memory.Get(c.InterruptEnableAddress)
-	if enabled == 0 {
+	pending := cpu.pendingInterrupts()
+	if pending == 0 {
 		return
 	}
 
-	for _, interrupt := range Interrupts {
-		if utils.IsSet(byte(interrupt), requested) && utils.IsSet(byte(interrupt), enabled) {
-			if cpu.halt {
-				cpu.RunFor(4)
-			}
-			cpu.halt = false
-			if !cpu.interruptsEnabled() {
-				return
-			}
-			returnAddress := cpu.GetPC()
-			cpu.serviceInterrupt(interrupt, returnAddress)
-			return
+	// HALT exits on any pending interrupt, even with IME disabled
+	if cpu.halt {
+		cpu.halt = false
+		if cpu.interruptsEnabled() {
+			cpu.RunFor(ClocksPerCycle)
 		}
 	}
+	if !cpu.interruptsEnabled() {
+		return
+	}
 
+	cpu.serviceInterrupt(cpu.GetPC())
+}
+
+func (cpu *CPU) pendingInterrupts() byte {
+	requested := cpu.memory.Get(c.InterruptFlagAddress)
+	enabled := cpu.memory.Get(c.InterruptEnableAddress)
+	return requested & enabled & InterruptMask
 }
 
 func (cpu *CPU) requestInterrupt(interrupt Interrupt) {
@@ -60,26 +62,40 @@ func (cpu *CPU) clearInterrupt(interrupt Interrupt) {
 	cpu.setBitAt(c.InterruptFlagAddress, byte(interrupt), 0)
 }
 
-func (cpu *CPU) serviceInterrupt(interrupt Interrupt, returnAddress uint16) {
+// Dispatch takes five M-cycles: two idle cycles, two to push PC and one to
+// jump. The interrupt is only chosen after the high byte of PC is pushed, so
+// a push that overwrites IE can change it or cancel dispatch to 0x0000.
+func (cpu *CPU) serviceInterrupt(returnAddress uint16) {
 	cpu.disableInterrupts()
-	cpu.clearInterrupt(interrupt)
-
-	cpu.RunFor(20)
+	cpu.RunFor(2 * ClocksPerCycle)
 
 	high, low := utils.SplitPair(returnAddress)
-
 	cpu.pushStack(high)
+	pending := cpu.pendingInterrupts()
 	cpu.pushStack(low)
 
+	cpu.PC = 0x0000
+	for _, interrupt := range Interrupts {
+		if utils.IsSet(byte(interrupt), pending) {
+			cpu.clearInterrupt(interrupt)
+			cpu.PC = interruptHandlerAddress(interrupt)
+			break
+		}
+	}
+	cpu.RunFor(ClocksPerCycle)
+}
+
+func interruptHandlerAddress(interrupt Interrupt) uint16 {
 	switch interrupt {
 	case VBlank:
-		cpu.setPC(VBlankInterruptHandlerAddress)
+		return VBlankInterruptHandlerAddress
 	case LCDCStatus:
-		cpu.setPC(LCDCStatusInterruptHandlerAddress)
+		return LCDCStatusInterruptHandlerAddress
 	case TimerOverflow:
-		cpu.setPC(TimerOverflowInterruptHandlerAddress)
-	case Input:
-		cpu.setPC(InputInterruptHandlerAddress)
+		return TimerOverflowInterruptHandlerAddress
+	case Serial:
+		return SerialInterruptHandlerAddress
+	default:
+		return InputInterruptHandlerAddress
 	}
-	cpu.decrementCycles() // because setPC() increments
 }
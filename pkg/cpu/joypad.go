@@ -29,6 +29,7 @@ type selection byte
 const (
 	directional    selection = 0x20
 	nonDirectional           = 0x10
+	bothSelected             = 0x00
 )
 
 func (cpu *CPU) PressButton(button Button) {
@@ -55,12 +56,19 @@ func (cpu *CPU) WriteJoypad(value byte) {
 	cpu.joypadInternalState.selection = selection(value & joypadSelectionMask)
 }
 
+// STOP mode is exited when any selected input line goes low
+func (cpu *CPU) isJoypadLineLow() bool {
+	return cpu.ReadJoypad()&0xf != 0xf
+}
+
 func (joypad *Joypad) toRegisterFormat() byte {
 	switch joypad.selection {
 	case directional:
 		return (^(joypad.buttons & 0xf) & 0xf) | byte(joypad.selection)
 	case nonDirectional:
 		return (^(joypad.buttons >> 4) & 0xf) | byte(joypad.selection)
+	case bothSelected:
+		return (^(joypad.buttons | joypad.buttons>>4) & 0xf) | byte(joypad.selection)
 	default:
 		return byte(joypad.selection) | 0xf
 	}
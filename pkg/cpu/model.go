@@ -0,0 +1,8 @@
+package cpu
+
+type Model byte
+
+const (
+	DMG Model = iota
+	CGB
+)
@@ -0,0 +1,86 @@
+package cpu
+
+// On DMG, putting an address in 0xFE00-0xFEFF on the bus while the PPU is
+// searching OAM corrupts the row of OAM it is currently reading.
+const (
+	OAMBugStart      = 0xFE00
+	OAMBugEnd        = 0xFEFF
+	OAMRowSize       = 8
+	OAMRowCount      = 20
+	CyclesPerOAMRow  = 4
+	FirstIncreaseRow = 4
+	LastIncreaseRow  = OAMRowCount - 2
+)
+
+func isOAMBugAddress(address uint16) bool {
+	return address >= OAMBugStart && address <= OAMBugEnd
+}
+
+// accessedOAMRow returns the row the PPU is reading, if it is searching OAM
+func (gpu *GPU) accessedOAMRow() (int, bool) {
+	if gpu.cpu.model != DMG || !gpu.getControl().isDisplayEnabled() || gpu.scanline >= VBlankStartScanline {
+		return 0, false
+	}
+	dot := gpu.dot()
+	if dot >= CyclesPerSearchingOAMMode {
+		return 0, false
+	}
+	return int(dot / CyclesPerOAMRow), true
+}
+
+func (gpu *GPU) oamWord(row, word int) uint16 {
+	i := row*OAMRowSize + word*2
+	return uint16(gpu.sram[i]) | uint16(gpu.sram[i+1])<<8
+}
+
+func (gpu *GPU) setOAMWord(row, word int, value uint16) {
+	i := row*OAMRowSize + word*2
+	gpu.sram[i] = byte(value)
+	gpu.sram[i+1] = byte(value >> 8)
+}
+
+func (gpu *GPU) copyOAMRow(dest, source int) {
+	copy(gpu.sram[dest*OAMRowSize:(dest+1)*OAMRowSize], gpu.sram[source*OAMRowSize:(source+1)*OAMRowSize])
+}
+
+// corruptOAMRow replaces the first word of the current row using glitch and
+// copies the rest of the preceding row over it
+func (gpu *GPU) corruptOAMRow(glitch func(a, b, c uint16) uint16) {
+	row, ok := gpu.accessedOAMRow()
+	if !ok || row == 0 {
+		return
+	}
+	a := gpu.oamWord(row, 0)
+	b := gpu.oamWord(row-1, 0)
+	c := gpu.oamWord(row-1, 2)
+	gpu.copyOAMRow(row, row-1)
+	gpu.setOAMWord(row, 0, glitch(a, b, c))
+}
+
+func (gpu *GPU) triggerOAMWriteBug() {
+	gpu.corruptOAMRow(func(a, b, c uint16) uint16 {
+		return ((a ^ c) & (b ^ c)) ^ c
+	})
+}
+
+func (gpu *GPU) triggerOAMReadBug() {
+	gpu.corruptOAMRow(func(a, b, c uint16) uint16 {
+		return b | (a & c)
+	})
+}
+
+// Reading while the address is also incremented or decremented corrupts
+// the preceding rows before the usual read corruption takes place
+func (gpu *GPU) triggerOAMReadIncreaseBug() {
+	row, ok := gpu.accessedOAMRow()
+	if ok && row >= FirstIncreaseRow && row <= LastIncreaseRow {
+		a := gpu.oamWord(row-2, 0)
+		b := gpu.oamWord(row-1, 0)
+		c := gpu.oamWord(row, 0)
+		d := gpu.oamWord(row-1, 2)
+		gpu.setOAMWord(row-1, 0, (b&(a|c|d))|(a&c&d))
+		gpu.copyOAMRow(row, row-1)
+		gpu.copyOAMRow(row-2, row-1)
+	}
+	gpu.triggerOAMReadBug()
+}
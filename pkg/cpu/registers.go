@@ -29,7 +29,7 @@ func (cpu *CPU) GetPair(r registers.Pair) (byte, byte) {
 	case registers.SP:
 		return utils.SplitPair(cpu.GetSP())
 	default:
-		panic(fmt.Sprintf("GetPair: Invalid register %x", r))
+		panic(cpu.crash(fmt.Sprintf("GetPair: invalid register %x", r)))
 	}
 }
 
@@ -118,7 +118,7 @@ func (cpu *CPU) GetMem(r registers.Pair) byte {
 	case registers.SP:
 		return cpu.readMem(cpu.GetSP())
 	default:
-		panic(fmt.Sprintf("GetMem: Invalid register %x", r))
+		panic(cpu.crash(fmt.Sprintf("GetMem: invalid register %x", r)))
 	}
 }
 
@@ -133,7 +133,7 @@ func (cpu *CPU) SetMem(r registers.Pair, val byte) byte {
 	case registers.SP:
 		cpu.WriteMem(cpu.GetSP(), val)
 	default:
-		panic(fmt.Sprintf("SetMem: Invalid register %x", r))
+		panic(cpu.crash(fmt.Sprintf("SetMem: invalid register %x", r)))
 	}
 	return val
 }
@@ -0,0 +1,110 @@
+package cpu
+
+import (
+	"container/heap"
+	"math"
+)
+
+type eventKind byte
+
+// Events due on the same clock run in this order, matching the order the
+// components are updated in on each clock
+const (
+	timerReloadEvent eventKind = iota
+	timerEdgeEvent
+	displayEvent
+	dmaStartEvent
+	dmaTransferEvent
+	eventKindCount
+)
+
+const never uint64 = math.MaxUint64
+
+type event struct {
+	time  uint64
+	kind  eventKind
+	index int
+}
+
+// eventQueue is a min-heap of events ordered by time and then kind
+type eventQueue []*event
+
+func (q eventQueue) Len() int { return len(q) }
+
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].time == q[j].time {
+		return q[i].kind < q[j].kind
+	}
+	return q[i].time < q[j].time
+}
+
+func (q eventQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *eventQueue) Push(x interface{}) {
+	e := x.(*event)
+	e.index = len(*q)
+	*q = append(*q, e)
+}
+
+func (q *eventQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// The scheduler counts clocks and holds at most one pending event of each
+// kind, so peripherals only run when something can happen
+type scheduler struct {
+	now    uint64
+	events [eventKindCount]event
+	queue  eventQueue
+}
+
+func newScheduler() *scheduler {
+	s := &scheduler{}
+	for kind := range s.events {
+		s.events[kind] = event{time: never, kind: eventKind(kind)}
+		heap.Push(&s.queue, &s.events[kind])
+	}
+	return s
+}
+
+// schedule replaces any pending event of kind with one due after cycles
+func (s *scheduler) schedule(kind eventKind, cycles uint) {
+	s.events[kind].time = s.now + uint64(cycles)
+	heap.Fix(&s.queue, s.events[kind].index)
+}
+
+func (s *scheduler) cancel(kind eventKind) {
+	s.events[kind].time = never
+	heap.Fix(&s.queue, s.events[kind].index)
+}
+
+func (s *scheduler) isScheduled(kind eventKind) bool {
+	return s.events[kind].time != never
+}
+
+func (s *scheduler) postpone(kind eventKind, cycles uint) {
+	if s.isScheduled(kind) {
+		s.events[kind].time += uint64(cycles)
+		heap.Fix(&s.queue, s.events[kind].index)
+	}
+}
+
+// runFor advances the clock, handling each event as it falls due
+func (s *scheduler) runFor(cycles uint, handle func(kind eventKind)) {
+	end := s.now + uint64(cycles)
+	for s.queue[0].time <= end {
+		next := s.queue[0]
+		s.now = next.time
+		next.time = never
+		heap.Fix(&s.queue, 0)
+		handle(next.kind)
+	}
+	s.now = end
+}
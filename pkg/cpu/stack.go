@@ -1,7 +1,6 @@
 package cpu
 
 import (
-	c "github.com/tbtommyb/goboy/pkg/constants"
 	"github.com/tbtommyb/goboy/pkg/registers"
 )
 
@@ -11,7 +10,6 @@ func (cpu *CPU) GetSP() uint16 {
 }
 
 func (cpu *CPU) setSP(value uint16) uint16 {
-	cpu.incrementCycles()
 	cpu.SP = value
 	return value
 }
@@ -22,9 +20,6 @@ func (cpu *CPU) incrementSP() {
 
 func (cpu *CPU) decrementSP() {
 	cpu.SP -= 1
-	if cpu.SP > c.StackStartAddress {
-		cpu.SP = c.StackStartAddress
-	}
 }
 
 func (cpu *CPU) pushStack(val byte) byte {
@@ -33,7 +28,15 @@ func (cpu *CPU) pushStack(val byte) byte {
 }
 
 func (cpu *CPU) popStack() byte {
-	val := cpu.GetMem(registers.SP)
+	val := cpu.readMemIncrement(cpu.GetSP())
 	cpu.incrementSP()
 	return val
 }
+
+// popPair pops a little-endian pair. Each read happens while SP is being
+// incremented, which matters for the OAM bug.
+func (cpu *CPU) popPair() (high, low byte) {
+	low = cpu.popStack()
+	high = cpu.popStack()
+	return high, low
+}
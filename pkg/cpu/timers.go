@@ -8,47 +8,117 @@ import (
 const (
 	TimerControlBit      byte = 2
 	InputClockSelectMask      = 3
+	TimerControlMask          = 0x7
 )
 
-// f/2^10, f/2^4, f/2^6, f/2^8
-var inputClocks = []uint16{1024, 16, 64, 256}
+// Internal timer bit selected by TAC for f/2^10, f/2^4, f/2^6, f/2^8
+var inputClockBits = []uint16{1 << 9, 1 << 3, 1 << 5, 1 << 7}
 
-func (cpu *CPU) UpdateTimers() {
-	cpu.internalTimer++
+// TIMA is incremented on the falling edge of the selected internal timer bit
+// ANDed with the enable bit. When it overflows it reads 0 for one M-cycle
+// before being reloaded from TMA and requesting the interrupt.
+func (cpu *CPU) handleTimerEdge() {
+	cpu.incrementTIMA()
+	cpu.scheduleTimerEdge()
+}
 
-	if !cpu.isTimerEnabled() {
-		return
-	}
+func (cpu *CPU) reloadTIMA() {
+	cpu.WriteIO(c.TIMAAddress, cpu.ReadIO(c.TMAAddress))
+	cpu.requestInterrupt(TimerOverflow)
+	cpu.timaReloadWindowEnd = cpu.scheduler.now + uint64(ClocksPerCycle)
+}
+
+// The internal timer counts every clock, so it is kept as the clock it was
+// last zero at
+func (cpu *CPU) GetInternalTimer() uint16 {
+	return uint16(cpu.scheduler.now - cpu.timerBase)
+}
+
+func (cpu *CPU) setInternalTimer(value uint16) {
+	cpu.timerBase = cpu.scheduler.now - uint64(value)
+}
+
+func (cpu *CPU) ResetInternalTimer() {
+	cpu.changeTimerInput(func() {
+		cpu.setInternalTimer(0)
+	})
+}
+
+func (cpu *CPU) WriteTimerControl(value byte) {
+	cpu.changeTimerInput(func() {
+		cpu.WriteIO(c.TACAddress, 0xF8|(value&TimerControlMask))
+	})
+}
 
-	cpu.cyclesForCurrentTick--
-	if cpu.cyclesForCurrentTick > 0 {
+func (cpu *CPU) WriteTimerCounter(value byte) {
+	if cpu.inTIMAReloadWindow() {
+		// TMA wins when written in the same cycle as the reload
 		return
 	}
+	// Writing during the overflow delay cancels the reload and interrupt
+	cpu.scheduler.cancel(timerReloadEvent)
+	cpu.WriteIO(c.TIMAAddress, value)
+}
 
-	cpu.WriteIO(c.TIMAAddress, cpu.ReadIO(c.TIMAAddress)+1)
-	if cpu.ReadIO(c.TIMAAddress) == 0 {
-		cpu.WriteIO(c.TIMAAddress, cpu.ReadIO(c.TMAAddress))
-		cpu.requestInterrupt(TimerOverflow)
+func (cpu *CPU) WriteTimerModulo(value byte) {
+	cpu.WriteIO(c.TMAAddress, value)
+	if cpu.inTIMAReloadWindow() {
+		cpu.WriteIO(c.TIMAAddress, value)
 	}
-	cpu.ResetCyclesForTimerTick()
 }
 
-func (cpu *CPU) GetInternalTimer() uint16 {
-	return cpu.internalTimer
+func (cpu *CPU) inTIMAReloadWindow() bool {
+	return cpu.scheduler.now < cpu.timaReloadWindowEnd
+}
+
+// The timer is frozen in STOP mode
+func (cpu *CPU) pauseTimers(cycles uint) {
+	cpu.timerBase += uint64(cycles)
+	if cpu.inTIMAReloadWindow() {
+		cpu.timaReloadWindowEnd += uint64(cycles)
+	}
+	cpu.scheduler.postpone(timerReloadEvent, cycles)
+	cpu.scheduler.postpone(timerEdgeEvent, cycles)
 }
 
 func (cpu *CPU) isTimerEnabled() bool {
 	return utils.IsSet(TimerControlBit, cpu.ReadIO(c.TACAddress))
 }
 
-func (cpu *CPU) getClockFreq() uint16 {
-	return inputClocks[cpu.ReadIO(c.TACAddress)&InputClockSelectMask]
+func (cpu *CPU) selectedTimerBit() uint16 {
+	return inputClockBits[cpu.ReadIO(c.TACAddress)&InputClockSelectMask]
 }
 
-func (cpu *CPU) ResetCyclesForTimerTick() {
-	cpu.cyclesForCurrentTick = int(cpu.getClockFreq())
+func (cpu *CPU) timerSignal() bool {
+	return cpu.isTimerEnabled() && cpu.GetInternalTimer()&cpu.selectedTimerBit() > 0
 }
 
-func (cpu *CPU) ResetInternalTimer() {
-	cpu.internalTimer = 0
+// changeTimerInput applies a write to DIV or TAC, which increments TIMA if it
+// makes the timer signal fall
+func (cpu *CPU) changeTimerInput(change func()) {
+	before := cpu.timerSignal()
+	change()
+	if before && !cpu.timerSignal() {
+		cpu.incrementTIMA()
+	}
+	cpu.scheduleTimerEdge()
+}
+
+// The selected bit falls each time the internal timer passes a multiple of
+// twice its value
+func (cpu *CPU) scheduleTimerEdge() {
+	if !cpu.isTimerEnabled() {
+		cpu.scheduler.cancel(timerEdgeEvent)
+		return
+	}
+	period := 2 * uint(cpu.selectedTimerBit())
+	cpu.scheduler.schedule(timerEdgeEvent, period-uint(cpu.GetInternalTimer())%period)
+}
+
+func (cpu *CPU) incrementTIMA() {
+	tima := cpu.ReadIO(c.TIMAAddress) + 1
+	cpu.WriteIO(c.TIMAAddress, tima)
+	if tima == 0 {
+		cpu.scheduler.schedule(timerReloadEvent, ClocksPerCycle)
+	}
 }
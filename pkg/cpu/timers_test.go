@@ -0,0 +1,135 @@
+package cpu
+
+import (
+	"testing"
+
+	c "github.com/tbtommyb/goboy/pkg/constants"
+)
+
+func createTimerCPU(tac byte) *CPU {
+	cpu := Init(false)
+	cpu.AttachDisplay(&TestDisplay{})
+	cpu.memory.Set(c.TACAddress, tac)
+	cpu.memory.Set(c.DIVAddress, 0)
+	cpu.memory.Set(c.TIMAAddress, 0)
+	cpu.memory.Set(c.InterruptFlagAddress, 0)
+	return cpu
+}
+
+func tickTimers(cpu *CPU, cycles int) {
+	cpu.RunFor(uint(cycles))
+}
+
+func expectTIMA(t *testing.T, cpu *CPU, name string, expected byte) {
+	if actual := cpu.memory.Get(c.TIMAAddress); actual != expected {
+		t.Errorf("%s: expected TIMA to be %x, got %x", name, expected, actual)
+	}
+}
+
+func timerInterruptRequested(cpu *CPU) bool {
+	return cpu.memory.Get(c.InterruptFlagAddress)&(1<<TimerOverflow) > 0
+}
+
+func TestTimerFrequencies(t *testing.T) {
+	testCases := []struct {
+		tac    byte
+		period int
+	}{
+		{tac: 0x4, period: 1024},
+		{tac: 0x5, period: 16},
+		{tac: 0x6, period: 64},
+		{tac: 0x7, period: 256},
+	}
+
+	for _, test := range testCases {
+		cpu := createTimerCPU(test.tac)
+
+		tickTimers(cpu, test.period*3-1)
+		expectTIMA(t, cpu, "before third edge", 2)
+		tickTimers(cpu, 1)
+		expectTIMA(t, cpu, "on third edge", 3)
+	}
+}
+
+func TestDividerWriteTicksTimer(t *testing.T) {
+	cpu := createTimerCPU(0x5)
+	tickTimers(cpu, 8)
+	expectTIMA(t, cpu, "selected bit high", 0)
+
+	cpu.memory.Set(c.DIVAddress, 0x12)
+
+	expectTIMA(t, cpu, "DIV write with selected bit high", 1)
+	if actual := cpu.memory.Get(c.DIVAddress); actual != 0 {
+		t.Errorf("Expected DIV to reset, got %x", actual)
+	}
+}
+
+func TestTimerControlWriteTicksTimer(t *testing.T) {
+	testCases := []struct {
+		tac      byte
+		expected byte
+		message  string
+	}{
+		{tac: 0x1, expected: 1, message: "disabling with selected bit high"},
+		{tac: 0x6, expected: 1, message: "switching to a low bit"},
+		{tac: 0x5, expected: 0, message: "rewriting the same value"},
+	}
+
+	for _, test := range testCases {
+		cpu := createTimerCPU(0x5)
+		tickTimers(cpu, 8)
+
+		cpu.memory.Set(c.TACAddress, test.tac)
+
+		expectTIMA(t, cpu, test.message, test.expected)
+	}
+}
+
+func TestTimerOverflowReload(t *testing.T) {
+	cpu := createTimerCPU(0x5)
+	cpu.memory.Set(c.TMAAddress, 0xAB)
+	cpu.memory.Set(c.TIMAAddress, 0xFF)
+
+	tickTimers(cpu, 16)
+	expectTIMA(t, cpu, "overflow", 0)
+	if timerInterruptRequested(cpu) {
+		t.Errorf("Expected interrupt to be delayed after overflow")
+	}
+
+	tickTimers(cpu, int(ClocksPerCycle))
+	expectTIMA(t, cpu, "reload", 0xAB)
+	if !timerInterruptRequested(cpu) {
+		t.Errorf("Expected interrupt after reload")
+	}
+}
+
+func TestTimerReloadWrites(t *testing.T) {
+	testCases := []struct {
+		delay       int
+		address     uint16
+		value       byte
+		expected    byte
+		interrupted bool
+		message     string
+	}{
+		{delay: 0, address: c.TIMAAddress, value: 0x12, expected: 0x12, interrupted: false, message: "TIMA write during delay cancels reload"},
+		{delay: 0, address: c.TMAAddress, value: 0x12, expected: 0x12, interrupted: true, message: "TMA write during delay is reloaded"},
+		{delay: int(ClocksPerCycle), address: c.TIMAAddress, value: 0x12, expected: 0xAB, interrupted: true, message: "TIMA write during reload is ignored"},
+		{delay: int(ClocksPerCycle), address: c.TMAAddress, value: 0x12, expected: 0x12, interrupted: true, message: "TMA write during reload is copied"},
+	}
+
+	for _, test := range testCases {
+		cpu := createTimerCPU(0x5)
+		cpu.memory.Set(c.TMAAddress, 0xAB)
+		cpu.memory.Set(c.TIMAAddress, 0xFF)
+		tickTimers(cpu, 16+test.delay)
+
+		cpu.memory.Set(test.address, test.value)
+		tickTimers(cpu, int(ClocksPerCycle))
+
+		expectTIMA(t, cpu, test.message, test.expected)
+		if actual := timerInterruptRequested(cpu); actual != test.interrupted {
+			t.Errorf("%s: expected interrupt to be %t", test.message, test.interrupted)
+		}
+	}
+}
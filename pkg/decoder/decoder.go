@@ -10,8 +10,10 @@ type Iterator interface {
 	Next() byte
 }
 
-func Decode(il Iterator) in.Instruction {
-	op := il.Next()
+// decode matches an opcode against each instruction pattern, reading any
+// operands from il. Decoding goes through the opcode tables instead, which
+// only fall back to this when building instructions that carry operands
+func decode(op byte, il Iterator) in.Instruction {
 	var instruction in.Instruction
 	switch {
 	case op == in.HaltPattern:
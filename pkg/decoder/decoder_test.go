@@ -161,3 +161,63 @@ func TestOpcode(t *testing.T) {
 		}
 	}
 }
+
+func TestDecoderCache(t *testing.T) {
+	var d Decoder
+	programs := [][]byte{
+		{0xC3, 0x50, 0x01},
+		{0xC3, 0x50, 0x02},
+		{0xC3, 0x50, 0x01},
+		{0x18, 0xFE},
+		{0x10, 0x01},
+		{0xCB, 0x37},
+	}
+	for _, program := range programs {
+		expected := Decode(&in.List{Instructions: program})
+		actual := d.Decode(&in.List{Instructions: program})
+		if actual != expected {
+			t.Errorf("Expected %#v, got %#v", expected, actual)
+		}
+	}
+}
+
+var benchmarkPrograms = []struct {
+	name         string
+	program      []byte
+	instructions int
+}{
+	{"mixed", []byte{0x06, 0x12, 0x80, 0xC3, 0x50, 0x01, 0xCB, 0x37}, 4},
+	// Operands sharing a low byte must not evict each other
+	{"same low byte", []byte{0xC3, 0x34, 0x12, 0xC3, 0x34, 0x56, 0x06, 0x34, 0x01, 0x34, 0x56}, 4},
+}
+
+func decodeAll(d *Decoder, il *in.List, program []byte, instructions int) {
+	il.Load(program)
+	for i := 0; i < instructions; i++ {
+		d.Decode(il)
+	}
+}
+
+func TestDecoderDoesNotAllocate(t *testing.T) {
+	for _, test := range benchmarkPrograms {
+		var d Decoder
+		var il in.List
+		decodeAll(&d, &il, test.program, test.instructions)
+		if allocs := testing.AllocsPerRun(100, func() { decodeAll(&d, &il, test.program, test.instructions) }); allocs != 0 {
+			t.Errorf("%s: expected no allocations once decoded, got %v", test.name, allocs)
+		}
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	for _, test := range benchmarkPrograms {
+		b.Run(test.name, func(b *testing.B) {
+			var d Decoder
+			var il in.List
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				decodeAll(&d, &il, test.program, test.instructions)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package decoder
+
+import (
+	in "github.com/tbtommyb/goboy/pkg/instructions"
+	"github.com/tbtommyb/goboy/pkg/utils"
+)
+
+type opcode struct {
+	instruction in.Instruction
+	operands    int
+}
+
+var opcodes [0x100]opcode
+var prefixed [0x100]in.Instruction
+
+// operandIterator yields the bytes of an operand least significant first and
+// counts how many were read
+type operandIterator struct {
+	operand uint16
+	read    int
+}
+
+func (it *operandIterator) Next() byte {
+	value := byte(it.operand >> (8 * uint(it.read)))
+	it.read++
+	return value
+}
+
+func init() {
+	for op := range opcodes {
+		if byte(op) == in.Prefix {
+			continue
+		}
+		it := operandIterator{}
+		instruction := decode(byte(op), &it)
+		opcodes[op] = opcode{instruction: instruction, operands: it.read}
+	}
+	for op := range prefixed {
+		prefixed[op] = decode(in.Prefix, &operandIterator{operand: uint16(op)})
+	}
+}
+
+func readOperand(il Iterator, operands int) uint16 {
+	switch operands {
+	case 1:
+		return uint16(il.Next())
+	case 2:
+		return utils.ReverseMergePair(il.Next(), il.Next())
+	}
+	return 0
+}
+
+func withOperand(op byte, operand uint16) in.Instruction {
+	return decode(op, &operandIterator{operand: operand})
+}
+
+// Decode reads one instruction from il
+func Decode(il Iterator) in.Instruction {
+	op := il.Next()
+	if op == in.Prefix {
+		return prefixed[il.Next()]
+	}
+	entry := opcodes[op]
+	if entry.operands == 0 {
+		return entry.instruction
+	}
+	return withOperand(op, readOperand(il, entry.operands))
+}
+
+// A Decoder remembers instructions built with operands so that decoding a
+// running program stops allocating once its loops have been seen
+type Decoder struct {
+	bytes [0x100]*[0x100]in.Instruction
+	words map[uint32]in.Instruction
+}
+
+func (d *Decoder) Decode(il Iterator) in.Instruction {
+	op := il.Next()
+	if op == in.Prefix {
+		return prefixed[il.Next()]
+	}
+	entry := opcodes[op]
+	switch entry.operands {
+	case 0:
+		return entry.instruction
+	case 1:
+		return d.decodeByte(op, il.Next())
+	}
+	return d.decodeWord(op, readOperand(il, entry.operands))
+}
+
+func (d *Decoder) decodeByte(op, operand byte) in.Instruction {
+	row := d.bytes[op]
+	if row == nil {
+		row = new([0x100]in.Instruction)
+		d.bytes[op] = row
+	}
+	if row[operand] == nil {
+		row[operand] = withOperand(op, uint16(operand))
+	}
+	return row[operand]
+}
+
+func (d *Decoder) decodeWord(op byte, operand uint16) in.Instruction {
+	key := uint32(op)<<16 | uint32(operand)
+	if instruction, ok := d.words[key]; ok {
+		return instruction
+	}
+	if d.words == nil {
+		d.words = make(map[uint32]in.Instruction)
+	}
+	instruction := withOperand(op, operand)
+	d.words[key] = instruction
+	return instruction
+}
@@ -6,10 +6,15 @@ import (
 	"github.com/tbtommyb/goboy/pkg/constants"
 )
 
+// Display draws into a back buffer and swaps it with the front buffer when a
+// frame ends, so Pixels and Image always return a complete frame
 type Display struct {
 	buffer *image.RGBA
+	frame  *image.RGBA
 }
 
+func (d *Display) StartFrame() {}
+
 func (d *Display) WritePixel(x, y, r, g, b byte) {
 	yIdx := int(y)*160 + int(x)
 	d.buffer.Pix[yIdx*4] = byte(r)
@@ -18,12 +23,21 @@ func (d *Display) WritePixel(x, y, r, g, b byte) {
 	d.buffer.Pix[yIdx*4+3] = 0xff
 }
 
+func (d *Display) EndFrame() {
+	d.buffer, d.frame = d.frame, d.buffer
+}
+
 func (d *Display) Pixels() []uint8 {
-	return d.buffer.Pix
+	return d.frame.Pix
+}
+
+func (d *Display) Image() *image.RGBA {
+	return d.frame
 }
 
 func Init() *Display {
 	return &Display{
 		buffer: image.NewRGBA(image.Rect(0, 0, constants.ScreenWidth, constants.ScreenHeight)),
+		frame:  image.NewRGBA(image.Rect(0, 0, constants.ScreenWidth, constants.ScreenHeight)),
 	}
 }
@@ -0,0 +1,22 @@
+package display
+
+import "testing"
+
+func TestFrameOnlyChangesWhenComplete(t *testing.T) {
+	d := Init()
+	d.StartFrame()
+	d.WritePixel(1, 0, 0x12, 0x34, 0x56)
+	if actual := d.Pixels()[4]; actual != 0 {
+		t.Errorf("Expected incomplete frame to be hidden, got %x", actual)
+	}
+	d.EndFrame()
+	if actual := d.Pixels()[4]; actual != 0x12 {
+		t.Errorf("Expected %x, got %x", 0x12, actual)
+	}
+
+	d.StartFrame()
+	d.WritePixel(1, 0, 0x78, 0x9A, 0xBC)
+	if actual := d.Image().Pix[4]; actual != 0x12 {
+		t.Errorf("Expected previous frame %x, got %x", 0x12, actual)
+	}
+}
@@ -0,0 +1,74 @@
+package memory
+
+const DMALength = 0xA0
+const CyclesPerDMAByte = 4
+const DMAStartDelay = 4
+
+// OAM DMA runs in the background, copying one byte per M-cycle. While it is
+// active the CPU can't use the bus DMA is reading from and OAM is blocked.
+type dma struct {
+	active        bool
+	source        uint16
+	index         uint16
+	value         byte
+	pendingSource uint16
+}
+
+// A restarted DMA keeps the old transfer running until the new one begins
+func (m *Memory) startDMA(value byte) {
+	m.dma.pendingSource = uint16(value) << 8
+	m.cpu.ScheduleDMA(DMAStartDelay)
+}
+
+// BeginDMA starts the pending transfer and returns the clocks until its
+// first byte is copied
+func (m *Memory) BeginDMA() uint {
+	d := &m.dma
+	d.active = true
+	d.source = d.pendingSource
+	d.index = 0
+	return CyclesPerDMAByte - 1
+}
+
+// TransferDMA copies the next byte and returns the clocks until the one after,
+// or 0 once the transfer is complete
+func (m *Memory) TransferDMA() uint {
+	d := &m.dma
+	d.value = m.read(dmaSourceAddress(d.source + d.index))
+	m.cpu.WriteOAMDMA(OAMStart+d.index, d.value)
+	d.index++
+	if d.index == DMALength {
+		d.active = false
+		return 0
+	}
+	return CyclesPerDMAByte
+}
+
+func (m *Memory) isDMAConflict(address uint16) bool {
+	if !m.dma.active || address >= 0xFF00 {
+		return false
+	}
+	if address >= OAMStart {
+		return true
+	}
+	return isVRAMBus(address) == isVRAMBus(m.dma.source)
+}
+
+func (m *Memory) dmaConflictRead(address uint16) byte {
+	if address >= OAMStart {
+		return 0xFF
+	}
+	return m.dma.value
+}
+
+func isVRAMBus(address uint16) bool {
+	return address >= 0x8000 && address <= 0x9FFF
+}
+
+// Sources above 0xDFFF read from work RAM
+func dmaSourceAddress(address uint16) uint16 {
+	if address >= 0xE000 {
+		return address - 0x2000
+	}
+	return address
+}
@@ -1,8 +1,10 @@
 package memory
 
 import (
-	"fmt"
+	"io"
 
+	"github.com/tbtommyb/goboy/pkg/cartridge"
+	"github.com/tbtommyb/goboy/pkg/cheat"
 	c "github.com/tbtommyb/goboy/pkg/constants"
 )
 
@@ -13,67 +15,54 @@ type CPUInterface interface {
 	ReadOAM(address uint16) byte
 	WriteJoypad(value byte)
 	ReadJoypad() byte
+	WriteLCDStatus(value byte)
+	WriteLCDControl(value byte)
+	WriteLYCompare(value byte)
 	WriteIO(address uint16, value byte)
 	ReadIO(address uint16) byte
 	ResetInternalTimer()
 	GetInternalTimer() uint16
-	ResetCyclesForTimerTick()
+	WriteTimerCounter(value byte)
+	WriteTimerModulo(value byte)
+	WriteTimerControl(value byte)
 	BIOSLoaded() bool
-	RunFor(cycles uint)
+	WriteOAMDMA(address uint16, value byte)
+	ScheduleDMA(delay uint)
+	Clocks() uint64
 }
 
 type Memory struct {
-	rom             []byte
+	mapper          cartridge.Mapper
 	bios            [0x100]byte
-	eram            [0x8000]byte
 	wram            [0x2000]byte
 	hram            [0x7F]byte
 	interruptEnable byte
 	statMode        byte
 	cpu             CPUInterface
-	currentRAMBank  uint
-	currentROMBank  uint
-	enableRam       bool
-	ramAvailable    bool
-	ramBankSize     uint
-	bankingMode     BankingMode
-	bankingEnabled  bool
-	mbc             MBC
+	dma             dma
+	serialOutput    io.Writer
+	romCheats       map[uint16][]cheat.Cheat
 }
 
-const CartridgeTypeAddress = 0x147
-const ROMSizeAddress = 0x148
-const RAMSizeAddress = 0x149
-
-const RAMEnableLimit = 0x2000
-const ROMBankNumberLimit = 0x4000
-const RAMBankNumberLimit = 0x6000
-const ROMRAMModeSelectLimit = 0x8000
 const ProgramStartAddress = 0x100
 const OAMStart = 0xFE00
-const TACMask = 0x7
 const DMAAddress = 0xFF46
-const ROMBank00Limit = 0x4000
 const ROMBankLimit = 0x8000
-const ROMBankSize = 0x4000
-const CartRAMStart = 0xA000
-const CartRAMEnd = 0xBFFF
+const CartRAMStart = cartridge.RAMStart
+const CartRAMEnd = cartridge.RAMEnd
+
+var (
+	ErrUnsupportedMapper = cartridge.ErrUnsupportedMapper
+	ErrTruncatedROM      = cartridge.ErrTruncatedROM
+	ErrHeaderMismatch    = cartridge.ErrHeaderMismatch
+)
 
 func Init(cpu CPUInterface) *Memory {
 	return &Memory{
-		bios:           [0x100]byte{},
-		eram:           [0x8000]byte{},
-		wram:           [0x2000]byte{},
-		hram:           [0x7F]byte{},
-		currentRAMBank: 0,
-		currentROMBank: 1,
-		cpu:            cpu,
-	}
-}
-
-func (m *Memory) load(start uint, data []byte) {
-	for i := 0; i < len(data); i++ {
-		m.rom[start+uint(i)] = data[i]
+		bios: [0x100]byte{},
+		wram: [0x2000]byte{},
+		hram: [0x7F]byte{},
+		cpu:  cpu,
 	}
 }
 
@@ -83,41 +72,39 @@ func (m *Memory) LoadBIOS(program []byte) {
 	}
 }
 
+// SetSerialOutput sets where bytes sent over the serial port are written.
+// Test ROMs that report through cartridge RAM are written there too.
+func (m *Memory) SetSerialOutput(w io.Writer) {
+	m.serialOutput = w
+}
+
+func (m *Memory) writeSerialOutput(data []byte) {
+	if m.serialOutput != nil {
+		m.serialOutput.Write(data)
+	}
+}
+
 func (m *Memory) Set(address uint16, value byte) {
+	if m.isDMAConflict(address) {
+		return
+	}
 	switch {
 	case address < ROMBankLimit:
-		if m.bankingEnabled {
-			m.handleBanking(address, value)
-		}
+		m.mapper.WriteControl(address, value)
 	case address >= 0x8000 && address <= 0x9FFF:
 		// video ram
 		m.cpu.WriteVRAM(address, value)
 	case address >= CartRAMStart && address <= CartRAMEnd:
-		offset := uint(address - CartRAMStart)
+		m.mapper.WriteRAM(address, value)
 		// blargg oam_bug test output
-		if offset == 0x00 && m.eram[1] == 0xDE && m.eram[2] == 0xB0 && m.eram[3] == 0x61 {
-			if value != 0x80 {
-				for i := 4; m.eram[i] != 0x0; i++ {
-					fmt.Printf("%s", string(m.eram[i]))
-				}
-			}
-		}
-		if !m.enableRam {
-			// TODO: check if this should exist outside of TEST mode
-			m.eram[offset] = value
-			return
-		}
-		switch m.mbc {
-		case MBC1:
-			m.eram[offset+(m.currentRAMBank*m.ramBankSize)] = value
-		case MBC2:
-			m.eram[offset] = value & 0xF // lower 4 bits only
+		if address == CartRAMStart && value != 0x80 {
+			m.writeTestOutput()
 		}
 	case address >= 0xC000 && address <= 0xDFFF:
 		m.wram[address-0xC000] = value
 	case address >= 0xE000 && address <= 0xFDFF:
 		// shadow wram
-		m.Set(address-0x2000, value)
+		m.wram[address-0xE000] = value
 	case address >= 0xFE00 && address <= 0xFE9F:
 		// sprites
 		m.cpu.WriteOAM(address, value)
@@ -126,7 +113,7 @@ func (m *Memory) Set(address uint16, value byte) {
 	case address >= 0xFF00 && address <= 0xFF7F:
 		// memory mapped IO
 		if address == 0xFF01 {
-			fmt.Printf("%c", value)
+			m.writeSerialOutput([]byte{value})
 		} else if address == c.JoypadRegisterAddress {
 			m.cpu.WriteJoypad(value)
 		} else if address == c.LYAddress {
@@ -136,20 +123,22 @@ func (m *Memory) Set(address uint16, value byte) {
 			m.cpu.WriteIO(address, 0)
 			m.cpu.ResetInternalTimer()
 		} else if address == DMAAddress {
-			// DMA
-			m.performDMA(uint16(value) << 8)
+			m.cpu.WriteIO(address, value)
+			m.startDMA(value)
+		} else if address == c.TIMAAddress {
+			m.cpu.WriteTimerCounter(value)
+		} else if address == c.TMAAddress {
+			m.cpu.WriteTimerModulo(value)
 		} else if address == c.TACAddress {
-			newVal := value & TACMask
-			oldVal := m.cpu.ReadIO(address) & TACMask
-			m.cpu.WriteIO(address, 0xF8|newVal)
-			if newVal != oldVal {
-				m.cpu.ResetCyclesForTimerTick()
-			}
+			m.cpu.WriteTimerControl(value)
 		} else if address == 0xFF0A {
 			m.cpu.WriteIO(address, 0)
 		} else if address == c.STATAddress {
-			readOnlyBits := m.cpu.ReadIO(address) & 7
-			m.cpu.WriteIO(address, (value&0xF8)|readOnlyBits|0x80)
+			m.cpu.WriteLCDStatus(value)
+		} else if address == c.LCDCAddress {
+			m.cpu.WriteLCDControl(value)
+		} else if address == c.LYCAddress {
+			m.cpu.WriteLYCompare(value)
 		} else if address == c.InterruptFlagAddress {
 			m.cpu.WriteIO(address, 0xE0|(value&0x1F))
 		} else {
@@ -163,37 +152,34 @@ func (m *Memory) Set(address uint16, value byte) {
 }
 
 func (m *Memory) Get(address uint16) byte {
+	if m.isDMAConflict(address) {
+		return m.dmaConflictRead(address)
+	}
+	return m.read(address)
+}
+
+func (m *Memory) read(address uint16) byte {
 	switch {
 	case address < 0x100:
 		// TODO: find neater solution
 		if m.cpu.BIOSLoaded() {
 			return m.bios[address]
 		} else {
-			return m.rom[address]
+			return m.readROM(address)
 		}
-	case address < ROMBank00Limit:
-		return m.rom[address]
-	case address >= ROMBank00Limit && address < ROMBankLimit:
-		if !m.bankingEnabled {
-			return m.rom[address]
-		}
-		offset := uint(address - ROMBank00Limit)
-		return m.rom[offset+(m.currentROMBank*ROMBankSize)]
+	case address < ROMBankLimit:
+		return m.readROM(address)
 	case address >= ROMBankLimit && address <= 0x9FFF:
 		// video ram
 		return m.cpu.ReadVRAM(address)
 	case address >= CartRAMStart && address <= CartRAMEnd:
 		// cart ram
-		if !m.enableRam || !m.ramAvailable {
-			return 0xFF
-		}
-		offset := uint(address - CartRAMStart)
-		return m.eram[offset+(m.currentRAMBank*m.ramBankSize)]
+		return m.mapper.ReadRAM(address)
 	case address >= 0xC000 && address <= 0xDFFF:
 		return m.wram[address-0xC000]
 	case address >= 0xE000 && address <= 0xFDFF:
 		// shadow wram
-		return m.Get(address - 0x2000)
+		return m.wram[address-0xE000]
 	case address >= 0xFE00 && address <= 0xFE9F:
 		// sprites
 		return m.cpu.ReadOAM(address)
@@ -215,72 +201,114 @@ func (m *Memory) Get(address uint16) byte {
 		// 	return 1
 		// }
 		return m.hram[address-0xFF80]
-	case address == c.InterruptEnableAddress:
-		return m.interruptEnable
 	default:
-		panic(fmt.Sprintf("%x\n", address))
+		// interrupt enable register
+		return m.interruptEnable
 	}
 }
 
-func (m *Memory) performDMA(address uint16) {
-	m.cpu.RunFor(4)
-	for i := uint16(0); i < 0xA0; i++ {
-		m.cpu.RunFor(4)
-		m.Set(0xFE00+i, m.Get(address+i))
+// readROM reads from the cartridge, patched by any Game Genie codes
+func (m *Memory) readROM(address uint16) byte {
+	value := m.mapper.ReadROM(address)
+	if m.romCheats == nil {
+		return value
 	}
+	for _, c := range m.romCheats[address] {
+		if !c.HasCompare || c.Compare == value {
+			return c.Value
+		}
+	}
+	return value
+}
+
+// SetGameGenie replaces the Game Genie codes patching ROM reads
+func (m *Memory) SetGameGenie(cheats []cheat.Cheat) {
+	m.romCheats = nil
+	for _, c := range cheats {
+		if m.romCheats == nil {
+			m.romCheats = make(map[uint16][]cheat.Cheat)
+		}
+		m.romCheats[c.Address] = append(m.romCheats[c.Address], c)
+	}
+}
+
+// ApplyGameShark writes the values of GameShark codes, the way the device
+// does each VBlank. Codes with a bank write to that bank of cartridge RAM.
+func (m *Memory) ApplyGameShark(cheats []cheat.Cheat) {
+	for _, c := range cheats {
+		if !c.Banked {
+			m.Set(c.Address, c.Value)
+			continue
+		}
+		if banked, ok := m.mapper.(cartridge.BankedRAM); ok {
+			banked.WriteRAMBank(c.Bank, c.Address, c.Value)
+		} else if c.Bank == 0 {
+			m.mapper.WriteRAM(c.Address, c.Value)
+		}
+	}
+}
+
+// LoadROM checks the cartridge header against the ROM and loads it. Errors
+// wrap ErrTruncatedROM, ErrHeaderMismatch or ErrUnsupportedMapper.
+func (m *Memory) LoadROM(program []byte) error {
+	mapper, _, err := cartridge.NewMapper(program)
+	if err != nil {
+		return err
+	}
+	m.mapper = mapper
+	if clocked, ok := mapper.(cartridge.Clocked); ok {
+		clocked.ConnectClock(m.cpu.Clocks)
+	}
+	return nil
 }
 
-func (m *Memory) LoadROM(program []byte) {
-	cartridgeType := program[CartridgeTypeAddress]
-	romSize := program[ROMSizeAddress]
-	ramSize := program[RAMSizeAddress]
-	if romSize > 0 {
-		m.bankingEnabled = true
-		m.bankingMode = ROMBanking
+// ConnectInfrared connects the cartridge's IR port, if it has one
+func (m *Memory) ConnectInfrared(ir cartridge.Infrared) {
+	if port, ok := m.mapper.(cartridge.InfraredPort); ok {
+		port.ConnectInfrared(ir)
 	}
+}
 
-	switch cartridgeType {
-	case 1:
-		m.mbc = MBC1
-	case 2, 3:
-		m.mbc = MBC1
-		m.ramAvailable = true
-	case 5, 6:
-		m.mbc = MBC2
-	case 15, 16, 17, 18, 19:
-		panic("MBC3 not implemented")
+// ConnectCamera shows the cartridge's camera pictures from source, if it has
+// a camera
+func (m *Memory) ConnectCamera(source cartridge.ImageSource) {
+	if camera, ok := m.mapper.(cartridge.Camera); ok {
+		camera.ConnectCamera(source)
 	}
+}
 
-	switch romSize {
-	case 0x0:
-		m.rom = make([]byte, 0x8000)
-	case 0x1:
-		m.rom = make([]byte, 0x10000)
-	case 0x2:
-		m.rom = make([]byte, 0x20000)
-	case 0x3:
-		m.rom = make([]byte, 0x40000)
-	case 0x4:
-		m.rom = make([]byte, 0x80000)
-	case 0x5:
-		m.rom = make([]byte, 0x100000)
-	case 0x6:
-		m.rom = make([]byte, 0x200000)
-	case 0x7:
-		m.rom = make([]byte, 0x400000)
-	case 0x8:
-		m.rom = make([]byte, 0x800000)
+// SetAcceleration sets the force on the cartridge's accelerometer in g, if it
+// has one
+func (m *Memory) SetAcceleration(x, y float64) {
+	if accelerometer, ok := m.mapper.(cartridge.Accelerometer); ok {
+		accelerometer.SetAcceleration(x, y)
 	}
+}
 
-	switch ramSize {
-	case 0x0:
-		m.ramBankSize = 0x0
-	case 0x1:
-		m.ramBankSize = 0x800
-	case 0x2:
-		m.ramBankSize = 0x2000
-	case 0x3:
-		m.ramBankSize = 0x8000
+// SaveData returns the contents of battery-backed cartridge RAM, or nil if
+// the cartridge has no battery
+func (m *Memory) SaveData() []byte {
+	return m.mapper.SaveData()
+}
+
+func (m *Memory) LoadSaveData(data []byte) {
+	m.mapper.LoadSaveData(data)
+}
+
+// writeTestOutput writes the text blargg's tests leave in cartridge RAM once
+// they find their signature there
+func (m *Memory) writeTestOutput() {
+	if m.mapper.ReadRAM(CartRAMStart+1) != 0xDE || m.mapper.ReadRAM(CartRAMStart+2) != 0xB0 ||
+		m.mapper.ReadRAM(CartRAMStart+3) != 0x61 {
+		return
+	}
+	var text []byte
+	for address := uint16(CartRAMStart + 4); address <= CartRAMEnd; address++ {
+		value := m.mapper.ReadRAM(address)
+		if value == 0 {
+			break
+		}
+		text = append(text, value)
 	}
-	m.load(0, program)
+	m.writeSerialOutput(text)
 }
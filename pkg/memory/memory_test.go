@@ -3,6 +3,9 @@ package memory
 import (
 	"testing"
 
+	"github.com/pkg/errors"
+	"github.com/tbtommyb/goboy/pkg/cartridge"
+	"github.com/tbtommyb/goboy/pkg/cheat"
 	c "github.com/tbtommyb/goboy/pkg/constants"
 )
 
@@ -62,146 +65,113 @@ func TestLYReset(t *testing.T) {
 	}
 }
 
-func TestMBC1(t *testing.T) {
-	m := createMem()
-
-	m.bankingEnabled = true
-	m.bankingMode = ROMBanking
-	m.rom = make([]byte, 0x200000)
-	m.mbc = MBC1
-
-	ramEnableTestCases := []struct {
-		address        uint16
-		input          byte
-		enableExpected bool
-	}{
-		{address: 0x0000, input: 0xAA, enableExpected: true},
-		{address: 0x1000, input: 0x0A, enableExpected: true},
-		{address: 0x1FFF, input: 0x12, enableExpected: false},
-		{address: 0x0FFF, input: 0xA0, enableExpected: false},
-	}
-
-	for _, test := range ramEnableTestCases {
-		m.Set(test.address, test.input)
-		if actual := m.Get(test.address); actual != 0x0 {
-			t.Errorf("%x should be read only. Got %x\n", test.address, actual)
+// tickDMA stands in for the CPU's scheduler, counting down to DMA events
+func tickDMA(m *Memory, cycles int) {
+	cpu := m.cpu.(*TestCPU)
+	for i := 0; i < cycles; i++ {
+		if cpu.dmaStart > 0 {
+			cpu.dmaStart--
+			if cpu.dmaStart == 0 {
+				cpu.dmaTransfer = m.BeginDMA()
+			}
 		}
-		if actual := m.enableRam; actual != test.enableExpected {
-			t.Errorf("RAM enable state is %t, expected %t\n", actual, test.enableExpected)
+		if cpu.dmaTransfer > 0 {
+			cpu.dmaTransfer--
+			if cpu.dmaTransfer == 0 {
+				cpu.dmaTransfer = m.TransferDMA()
+			}
 		}
 	}
+}
 
-	romBankTestCases := []struct {
-		lowerAddress, upperAddress uint16
-		lowerVal, upperVal         byte
-		expectedBank               uint
-	}{
-		{lowerAddress: 0x2000, lowerVal: 0x00, upperAddress: 0x4000, upperVal: 0x0, expectedBank: 0x1},
-		{lowerAddress: 0x2400, lowerVal: 0x01, upperAddress: 0x4800, upperVal: 0x1, expectedBank: 0x21},
-		{lowerAddress: 0x3000, lowerVal: 0x10, upperAddress: 0x5000, upperVal: 0x2, expectedBank: 0x50},
-		{lowerAddress: 0x3600, lowerVal: 0xf, upperAddress: 0x5FFF, upperVal: 0x3, expectedBank: 0x6F},
+func TestDMATransfer(t *testing.T) {
+	m := createMem()
+	for i := 0; i < DMALength; i++ {
+		m.Set(0xC100+uint16(i), byte(i+1))
 	}
+	m.Set(0xFF80, 0x42)
 
-	for _, test := range romBankTestCases {
-		m.Set(test.lowerAddress, test.lowerVal)
-		m.Set(test.upperAddress, test.upperVal)
-		if actual := m.Get(test.lowerAddress); actual != 0x0 {
-			t.Errorf("%x should be read only. Got %x\n", test.lowerAddress, actual)
-		}
-		if actual := m.Get(test.upperAddress); actual != 0x0 {
-			t.Errorf("%x should be read only. Got %x\n", test.upperAddress, actual)
-		}
-		if actual := m.currentROMBank; actual != test.expectedBank {
-			t.Errorf("Expected ROM bank %x, got %x\n", test.expectedBank, actual)
-		}
+	m.Set(DMAAddress, 0xC1)
+	if actual := m.Get(DMAAddress); actual != 0xC1 {
+		t.Errorf("Expected DMA register to read back %x, got %x", 0xC1, actual)
+	}
+	if actual := m.Get(0xC100); actual != 0x01 {
+		t.Errorf("Expected bus to be free during start delay, got %x", actual)
 	}
 
-	romRamSelectTestCases := []struct {
-		address      uint16
-		value        byte
-		expectedMode BankingMode
-	}{
-		{address: 0x6000, value: 0x0, expectedMode: ROMBanking},
-		{address: 0x6F00, value: 0x1, expectedMode: RAMBanking},
-		{address: 0x7000, value: 0x20, expectedMode: ROMBanking},
-		{address: 0x7FFF, value: 0x21, expectedMode: RAMBanking},
+	tickDMA(m, DMAStartDelay+CyclesPerDMAByte*3)
+
+	if actual := m.Get(0xD000); actual != 0x03 {
+		t.Errorf("Expected conflicting read to return DMA byte %x, got %x", 0x03, actual)
+	}
+	if actual := m.Get(0xFE00); actual != 0xFF {
+		t.Errorf("Expected OAM to be blocked during DMA, got %x", actual)
+	}
+	if actual := m.Get(0xFF80); actual != 0x42 {
+		t.Errorf("Expected HRAM to be accessible during DMA, got %x", actual)
 	}
+	m.Set(0xC000, 0x99)
 
-	for _, test := range romRamSelectTestCases {
-		m.Set(test.address, test.value)
-		if actual := m.Get(test.address); actual != 0x0 {
-			t.Errorf("%x should be read only. Got %x\n", test.address, actual)
-		}
-		if actual := m.bankingMode; actual != test.expectedMode {
-			t.Errorf("Expected mode %x, got %x\n", test.expectedMode, actual)
+	tickDMA(m, CyclesPerDMAByte*(DMALength-3))
+
+	for i := 0; i < DMALength; i++ {
+		if actual := m.Get(0xFE00 + uint16(i)); actual != byte(i+1) {
+			t.Errorf("Expected OAM %x to be %x, got %x", i, i+1, actual)
 		}
 	}
+	if actual := m.Get(0xC000); actual != 0x00 {
+		t.Errorf("Expected write during DMA to be ignored, got %x", actual)
+	}
 }
 
-func TestRomModeRamBankReset(t *testing.T) {
+func TestDMAFromVRAM(t *testing.T) {
 	m := createMem()
+	m.Set(0x8000, 0x11)
+	m.Set(0xC000, 0x22)
 
-	m.bankingEnabled = true
-	m.bankingMode = ROMBanking
-	m.rom = make([]byte, 0x200000)
-	m.mbc = MBC1
+	m.Set(DMAAddress, 0x80)
+	tickDMA(m, DMAStartDelay+CyclesPerDMAByte)
 
-	m.Set(0x3600, 0x1F)
-	m.Set(0x5FFF, 0x3)
-
-	if actual := m.currentROMBank; actual != 0x7F {
-		t.Errorf("Expected ROM bank %x, got %x\n", 0x7F, actual)
+	if actual := m.Get(0xC000); actual != 0x22 {
+		t.Errorf("Expected work RAM to be accessible during VRAM DMA, got %x", actual)
 	}
-	if actual := m.currentRAMBank; actual != 0 {
-		t.Errorf("Expected RAM bank %x, got %x\n", 0, actual)
+	if actual := m.Get(0x9000); actual != 0x11 {
+		t.Errorf("Expected VRAM read to return DMA byte, got %x", actual)
 	}
+}
 
-	m.Set(0x6FFF, 0x3)
-
-	if actual := m.bankingMode; actual != RAMBanking {
-		t.Errorf("Expected RAM banking, got %x\n", actual)
-	}
-	if actual := m.currentRAMBank; actual != 3 {
-		t.Errorf("Expected RAM bank %x, got %x\n", 3, actual)
-	}
-	if actual := m.currentROMBank; actual != 0x1F {
-		t.Errorf("Expected ROM bank %x, got %x\n", 0x1F, actual)
+func TestDMARestart(t *testing.T) {
+	m := createMem()
+	for i := 0; i < DMALength; i++ {
+		m.Set(0xC000+uint16(i), 0xAA)
+		m.Set(0xD000+uint16(i), 0xBB)
 	}
 
-	m.Set(0x6FFF, 0x40)
+	m.Set(DMAAddress, 0xC0)
+	tickDMA(m, DMAStartDelay+CyclesPerDMAByte*10)
+	m.Set(DMAAddress, 0xD0)
 
-	if actual := m.bankingMode; actual != ROMBanking {
-		t.Errorf("Expected ROM banking, got %x\n", actual)
-	}
-	if actual := m.currentRAMBank; actual != 0 {
-		t.Errorf("Expected RAM bank %x, got %x\n", 0, actual)
-	}
-	if actual := m.currentROMBank; actual != 0x7F {
-		t.Errorf("Expected ROM bank %x, got %x\n", 0x7F, actual)
+	if actual := m.Get(0xFE00); actual != 0xFF {
+		t.Errorf("Expected OAM to stay blocked while restarting, got %x", actual)
 	}
+	tickDMA(m, DMAStartDelay+CyclesPerDMAByte*DMALength)
 
-	m.Set(0x6FFF, 0x3)
-
-	if actual := m.bankingMode; actual != RAMBanking {
-		t.Errorf("Expected RAM banking, got %x\n", actual)
-	}
-	if actual := m.currentRAMBank; actual != 3 {
-		t.Errorf("Expected RAM bank %x, got %x\n", 3, actual)
-	}
-	if actual := m.currentROMBank; actual != 0x1F {
-		t.Errorf("Expected ROM bank %x, got %x\n", 0x1F, actual)
+	for _, i := range []uint16{0, 20, DMALength - 1} {
+		if actual := m.Get(0xFE00 + i); actual != 0xBB {
+			t.Errorf("Expected OAM %x to be copied from restarted DMA, got %x", i, actual)
+		}
 	}
-
 }
 
 type TestCPU struct {
 	ioram       [0x100]byte
 	timer       uint16
-	cyclesTimer uint
 	biosLoaded  bool
 	joypad      byte
 	vram        [0x2000]byte
 	sram        [0x100]byte
+	dmaStart    uint
+	dmaTransfer uint
 }
 
 func (cpu *TestCPU) WriteIO(address uint16, value byte) {
@@ -236,6 +206,19 @@ func (cpu *TestCPU) ReadJoypad() byte {
 	return cpu.joypad
 }
 
+func (cpu *TestCPU) WriteLCDStatus(value byte) {
+	readOnlyBits := cpu.ioram[c.STATAddress-0xFF00] & 7
+	cpu.ioram[c.STATAddress-0xFF00] = (value & 0xF8) | readOnlyBits | 0x80
+}
+
+func (cpu *TestCPU) WriteLCDControl(value byte) {
+	cpu.ioram[c.LCDCAddress-0xFF00] = value
+}
+
+func (cpu *TestCPU) WriteLYCompare(value byte) {
+	cpu.ioram[c.LYCAddress-0xFF00] = value
+}
+
 func (cpu *TestCPU) GetInternalTimer() uint16 {
 	return cpu.timer
 }
@@ -244,15 +227,33 @@ func (cpu *TestCPU) ResetInternalTimer() {
 	cpu.timer = 0
 }
 
-func (cpu *TestCPU) ResetCyclesForTimerTick() {
-	cpu.cyclesTimer = 0
+func (cpu *TestCPU) WriteTimerCounter(value byte) {
+	cpu.ioram[c.TIMAAddress-0xFF00] = value
+}
+
+func (cpu *TestCPU) WriteTimerModulo(value byte) {
+	cpu.ioram[c.TMAAddress-0xFF00] = value
+}
+
+func (cpu *TestCPU) WriteTimerControl(value byte) {
+	cpu.ioram[c.TACAddress-0xFF00] = 0xF8 | (value & 0x7)
 }
 
 func (cpu *TestCPU) BIOSLoaded() bool {
 	return cpu.biosLoaded
 }
 
-func (cpu *TestCPU) RunFor(cycles uint) {}
+func (cpu *TestCPU) WriteOAMDMA(address uint16, value byte) {
+	cpu.sram[address-0xFE00] = value
+}
+
+func (cpu *TestCPU) ScheduleDMA(delay uint) {
+	cpu.dmaStart = delay
+}
+
+func (cpu *TestCPU) Clocks() uint64 {
+	return 0
+}
 
 func createTestCPU() *TestCPU {
 	return &TestCPU{
@@ -264,3 +265,128 @@ func createTestCPU() *TestCPU {
 func createMem() *Memory {
 	return Init(createTestCPU())
 }
+
+func TestLoadROMErrors(t *testing.T) {
+	rom := func(size int, cartridgeType, romSize, ramSize byte) []byte {
+		program := make([]byte, size)
+		if size > cartridge.RAMSizeAddress {
+			program[cartridge.CartridgeTypeAddress] = cartridgeType
+			program[cartridge.ROMSizeAddress] = romSize
+			program[cartridge.RAMSizeAddress] = ramSize
+		}
+		return program
+	}
+	testCases := []struct {
+		program  []byte
+		expected error
+		message  string
+	}{
+		{rom(0x8000, 0x00, 0x00, 0x00), nil, "32KB ROM only"},
+		{rom(0x40000, 0x03, 0x03, 0x03), nil, "256KB MBC1 with RAM"},
+		{rom(0x100, 0x00, 0x00, 0x00), ErrTruncatedROM, "no header"},
+		{rom(0x10000, 0x01, 0x02, 0x00), ErrTruncatedROM, "shorter than header"},
+		{rom(0x10000, 0x01, 0x00, 0x00), nil, "longer than header"},
+		{rom(0x8000, 0x00, 0x09, 0x00), ErrHeaderMismatch, "unknown ROM size"},
+		{rom(0x8000, 0x00, 0x00, 0x09), ErrHeaderMismatch, "unknown RAM size"},
+		{rom(0x8000, 0x13, 0x00, 0x00), ErrUnsupportedMapper, "MBC3"},
+	}
+	for _, test := range testCases {
+		m := createMem()
+		if actual := errors.Cause(m.LoadROM(test.program)); actual != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.message, test.expected, actual)
+		}
+	}
+}
+
+func TestBankNumbersWrapToCartridgeSize(t *testing.T) {
+	m := createMem()
+	program := make([]byte, 0x10000)
+	program[cartridge.CartridgeTypeAddress] = 0x03
+	program[cartridge.ROMSizeAddress] = 0x01
+	program[cartridge.RAMSizeAddress] = 0x02
+	program[0x4000] = 0x11
+	if err := m.LoadROM(program); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Set(0x2000, 0x1D)
+	if actual := m.Get(0x4000); actual != 0x11 {
+		t.Errorf("Expected ROM bank 29 to wrap to bank 1 and read %x, got %x", 0x11, actual)
+	}
+
+	m.Set(0x0000, 0x0A)
+	m.Set(0x6000, 0x01)
+	m.Set(0x4000, 0x03)
+	m.Set(0xA000, 0x22)
+	m.Set(0x4000, 0x00)
+	if actual := m.Get(0xA000); actual != 0x22 {
+		t.Errorf("Expected RAM bank 3 to mirror bank 0 and read %x, got %x", 0x22, actual)
+	}
+}
+
+func TestMBC2RAM(t *testing.T) {
+	m := createMem()
+	program := make([]byte, 0x8000)
+	program[cartridge.CartridgeTypeAddress] = 0x06
+	if err := m.LoadROM(program); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Set(0x0000, 0x0A)
+	m.Set(0xA001, 0x9C)
+	if actual := m.Get(0xBE01); actual != 0xFC {
+		t.Errorf("Expected %x, got %x", 0xFC, actual)
+	}
+	if actual := m.SaveData(); len(actual) != cartridge.MBC2RAMSize || actual[1] != 0x0C {
+		t.Errorf("Expected %d bytes of save data with %x at 1", cartridge.MBC2RAMSize, 0x0C)
+	}
+}
+
+func TestCheats(t *testing.T) {
+	m := createMem()
+	program := make([]byte, 0x10000)
+	program[cartridge.CartridgeTypeAddress] = 0x03
+	program[cartridge.ROMSizeAddress] = 0x01
+	program[cartridge.RAMSizeAddress] = 0x03
+	program[0x4A17] = 0x11
+	program[0x8A17] = 0x22
+	if err := m.LoadROM(program); err != nil {
+		t.Fatal(err)
+	}
+
+	m.SetGameGenie([]cheat.Cheat{
+		{Kind: cheat.GameGenie, Address: 0x4A17, Value: 0x99, Compare: 0x22, HasCompare: true},
+		{Kind: cheat.GameGenie, Address: 0x0150, Value: 0x76},
+	})
+	if actual := m.Get(0x4A17); actual != 0x11 {
+		t.Errorf("Expected bank 1 not to match the compare byte and read %x, got %x", 0x11, actual)
+	}
+	m.Set(0x2000, 0x02)
+	if actual := m.Get(0x4A17); actual != 0x99 {
+		t.Errorf("Expected bank 2 to be patched to %x, got %x", 0x99, actual)
+	}
+	if actual := m.Get(0x0150); actual != 0x76 {
+		t.Errorf("Expected %x, got %x", 0x76, actual)
+	}
+	m.SetGameGenie(nil)
+	if actual := m.Get(0x4A17); actual != 0x22 {
+		t.Errorf("Expected the patch to be removed, got %x", actual)
+	}
+
+	m.Set(0x0000, 0x0A)
+	m.Set(0x6000, 0x01)
+	m.ApplyGameShark([]cheat.Cheat{
+		{Kind: cheat.GameShark, Address: 0xC123, Value: 0x63},
+		{Kind: cheat.GameShark, Address: 0xA010, Value: 0x42, Bank: 2, Banked: true},
+	})
+	if actual := m.Get(0xC123); actual != 0x63 {
+		t.Errorf("Expected %x, got %x", 0x63, actual)
+	}
+	if actual := m.Get(0xA010); actual != 0x00 {
+		t.Errorf("Expected RAM bank 0 to be left alone, got %x", actual)
+	}
+	m.Set(0x4000, 0x02)
+	if actual := m.Get(0xA010); actual != 0x42 {
+		t.Errorf("Expected RAM bank 2 to read %x, got %x", 0x42, actual)
+	}
+}
@@ -3,7 +3,7 @@ package registers
 type Single byte
 type Pair byte
 type Registers struct {
-	single map[Single]byte
+	single [8]byte
 	ioram  [0x100]byte
 }
 
@@ -28,8 +28,7 @@ const (
 
 func Init() *Registers {
 	return &Registers{
-		ioram:  [0x100]byte{},
-		single: make(map[Single]byte),
+		ioram: [0x100]byte{},
 	}
 }
 
@@ -0,0 +1,318 @@
+package romfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// Patch files are looked for next to the ROM with these extensions, in order
+var patchExtensions = []string{".ips", ".ups", ".bps"}
+
+var (
+	ipsMagic = []byte("PATCH")
+	ipsEOF   = []byte("EOF")
+	upsMagic = []byte("UPS1")
+	bpsMagic = []byte("BPS1")
+)
+
+// UPS and BPS end with the CRC32s of the source, target and patch
+const patchFooterSize = 12
+
+var (
+	ErrBadPatch = errors.New("patch is corrupt")
+	ErrChecksum = errors.New("patch checksum doesn't match")
+)
+
+// LoadPatched loads a ROM with Load and applies a patch to it in memory. If
+// patchPath is empty, a patch with the ROM's name and the extension .ips, .ups
+// or .bps is used if there is one.
+func LoadPatched(path, patchPath string) ([]byte, error) {
+	rom, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	if patchPath == "" {
+		patchPath = FindPatch(path)
+		if patchPath == "" {
+			return rom, nil
+		}
+	}
+	patch, err := ioutil.ReadFile(patchPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't read patch")
+	}
+	patched, err := Patch(rom, patch)
+	return patched, errors.Wrapf(err, "couldn't apply %s", patchPath)
+}
+
+// FindPatch returns the patch next to a ROM, or next to the archive it came
+// from and named as SavePath names saves, or "" if there isn't one
+func FindPatch(path string) string {
+	for _, extension := range patchExtensions {
+		patchPath := alongside(path, extension)
+		if info, err := os.Stat(patchPath); err == nil && !info.IsDir() {
+			return patchPath
+		}
+	}
+	return ""
+}
+
+// Patch applies an IPS, UPS or BPS patch, telling them apart by their first
+// bytes, and returns the patched ROM. rom isn't changed. UPS and BPS patches
+// are checked against the CRC32s they hold. Errors wrap ErrBadPatch or
+// ErrChecksum.
+func Patch(rom, patch []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(patch, ipsMagic):
+		return patchIPS(rom, patch[len(ipsMagic):])
+	case bytes.HasPrefix(patch, upsMagic):
+		return patchUPS(rom, patch)
+	case bytes.HasPrefix(patch, bpsMagic):
+		return patchBPS(rom, patch)
+	}
+	return nil, errors.Wrap(ErrBadPatch, "not an IPS, UPS or BPS patch")
+}
+
+// patchIPS applies records of a 3-byte offset, a 2-byte length and that many
+// bytes. A length of 0 repeats one byte instead. After EOF there may be a
+// 3-byte size to truncate the ROM to.
+func patchIPS(rom, patch []byte) ([]byte, error) {
+	out := append([]byte{}, rom...)
+	r := patchReader{data: patch}
+	for {
+		if bytes.HasPrefix(r.data[r.pos:], ipsEOF) {
+			r.pos += len(ipsEOF)
+			break
+		}
+		offset := int(r.bigEndian(3))
+		length := int(r.bigEndian(2))
+		var data []byte
+		if length == 0 {
+			length = int(r.bigEndian(2))
+			data = bytes.Repeat([]byte{r.byte()}, length)
+		} else {
+			data = r.bytes(length)
+		}
+		if r.err != nil || offset+length > MaxSize {
+			return nil, ErrBadPatch
+		}
+		if offset+length > len(out) {
+			out = append(out, make([]byte, offset+length-len(out))...)
+		}
+		copy(out[offset:], data)
+	}
+	if len(r.data)-r.pos == 3 {
+		if size := int(r.bigEndian(3)); size < len(out) {
+			out = out[:size]
+		}
+	}
+	return out, nil
+}
+
+// patchUPS XORs runs of bytes into the ROM, each run ending at a zero byte
+// and following a count of bytes to leave alone
+func patchUPS(rom, patch []byte) ([]byte, error) {
+	r, err := newChecksummedReader(patch, len(upsMagic))
+	if err != nil {
+		return nil, err
+	}
+	sourceSize, targetSize := r.number(), r.number()
+	if r.err != nil || sourceSize != uint64(len(rom)) || targetSize > MaxSize {
+		return nil, errors.Wrap(ErrChecksum, "ROM is the wrong size for the patch")
+	}
+	if err := r.checkSource(rom); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, targetSize)
+	copy(out, rom)
+	var pointer uint64
+	for r.pos < len(r.data) {
+		pointer += r.number()
+		for {
+			x := r.byte()
+			if r.err != nil {
+				return nil, ErrBadPatch
+			}
+			if x == 0 {
+				break
+			}
+			if pointer >= targetSize {
+				return nil, ErrBadPatch
+			}
+			out[pointer] ^= x
+			pointer++
+		}
+		pointer++
+	}
+	return out, r.checkTarget(out)
+}
+
+// BPS actions, in the bottom two bits of each one's number
+const (
+	bpsSourceRead = iota
+	bpsTargetRead
+	bpsSourceCopy
+	bpsTargetCopy
+)
+
+// patchBPS builds the patched ROM from the front, copying from the ROM, the
+// patch, or what has been built so far
+func patchBPS(rom, patch []byte) ([]byte, error) {
+	r, err := newChecksummedReader(patch, len(bpsMagic))
+	if err != nil {
+		return nil, err
+	}
+	sourceSize, targetSize := r.number(), r.number()
+	r.bytes(int(r.number())) // metadata
+	if r.err != nil || sourceSize != uint64(len(rom)) || targetSize > MaxSize {
+		return nil, errors.Wrap(ErrChecksum, "ROM is the wrong size for the patch")
+	}
+	if err := r.checkSource(rom); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, targetSize)
+	var sourceOffset, targetOffset int64
+	for r.pos < len(r.data) {
+		action := r.number()
+		length := int64(action>>2) + 1
+		if r.err != nil || uint64(len(out))+uint64(length) > targetSize {
+			return nil, ErrBadPatch
+		}
+		switch action & 0x3 {
+		case bpsSourceRead:
+			start := int64(len(out))
+			if start+length > int64(len(rom)) {
+				return nil, ErrBadPatch
+			}
+			out = append(out, rom[start:start+length]...)
+		case bpsTargetRead:
+			out = append(out, r.bytes(int(length))...)
+		case bpsSourceCopy:
+			sourceOffset += r.signedNumber()
+			if sourceOffset < 0 || sourceOffset+length > int64(len(rom)) {
+				return nil, ErrBadPatch
+			}
+			out = append(out, rom[sourceOffset:sourceOffset+length]...)
+			sourceOffset += length
+		case bpsTargetCopy:
+			targetOffset += r.signedNumber()
+			if targetOffset < 0 || targetOffset >= int64(len(out)) {
+				return nil, ErrBadPatch
+			}
+			// Byte by byte, as the copy may overlap what it writes
+			for i := int64(0); i < length; i++ {
+				out = append(out, out[targetOffset])
+				targetOffset++
+			}
+		}
+		if r.err != nil {
+			return nil, ErrBadPatch
+		}
+	}
+	if uint64(len(out)) != targetSize {
+		return nil, ErrBadPatch
+	}
+	return out, r.checkTarget(out)
+}
+
+type patchReader struct {
+	data []byte
+	pos  int
+	err  error
+
+	sourceCRC, targetCRC uint32
+}
+
+// newChecksummedReader checks the CRC32 of a UPS or BPS patch and returns a
+// reader for what lies between the magic and the footer
+func newChecksummedReader(patch []byte, magicSize int) (*patchReader, error) {
+	if len(patch) < magicSize+patchFooterSize {
+		return nil, ErrBadPatch
+	}
+	footer := patch[len(patch)-patchFooterSize:]
+	if crc32.ChecksumIEEE(patch[:len(patch)-4]) != binary.LittleEndian.Uint32(footer[8:]) {
+		return nil, errors.Wrap(ErrChecksum, "patch is damaged")
+	}
+	return &patchReader{
+		data:      patch[:len(patch)-patchFooterSize],
+		pos:       magicSize,
+		sourceCRC: binary.LittleEndian.Uint32(footer),
+		targetCRC: binary.LittleEndian.Uint32(footer[4:]),
+	}, nil
+}
+
+func (r *patchReader) checkSource(rom []byte) error {
+	if crc32.ChecksumIEEE(rom) != r.sourceCRC {
+		return errors.Wrap(ErrChecksum, "patch is for a different ROM")
+	}
+	return nil
+}
+
+func (r *patchReader) checkTarget(out []byte) error {
+	if crc32.ChecksumIEEE(out) != r.targetCRC {
+		return errors.Wrap(ErrChecksum, "patched ROM is wrong")
+	}
+	return nil
+}
+
+func (r *patchReader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || n > len(r.data)-r.pos {
+		r.err = ErrBadPatch
+		return nil
+	}
+	data := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return data
+}
+
+func (r *patchReader) byte() byte {
+	if data := r.bytes(1); data != nil {
+		return data[0]
+	}
+	return 0
+}
+
+func (r *patchReader) bigEndian(n int) uint {
+	var value uint
+	for _, b := range r.bytes(n) {
+		value = value<<8 | uint(b)
+	}
+	return value
+}
+
+// number reads UPS and BPS's variable-length numbers: 7 bits a byte, least
+// significant first, with the top bit set on the last byte
+func (r *patchReader) number() uint64 {
+	var value uint64
+	shift := uint64(1)
+	for i := 0; i < 10; i++ {
+		x := r.byte()
+		if r.err != nil {
+			return 0
+		}
+		value += uint64(x&0x7F) * shift
+		if x&0x80 != 0 {
+			return value
+		}
+		shift <<= 7
+		value += shift
+	}
+	r.err = ErrBadPatch
+	return 0
+}
+
+// signedNumber reads a BPS offset, whose bottom bit is the sign
+func (r *patchReader) signedNumber() int64 {
+	value := r.number()
+	if value&0x1 != 0 {
+		return -int64(value >> 1)
+	}
+	return int64(value >> 1)
+}
@@ -0,0 +1,154 @@
+package romfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// encodeNumber writes a UPS and BPS variable-length number
+func encodeNumber(value uint64) []byte {
+	var out []byte
+	for {
+		x := byte(value & 0x7F)
+		value >>= 7
+		if value == 0 {
+			return append(out, 0x80|x)
+		}
+		out = append(out, x)
+		value--
+	}
+}
+
+// withFooter adds the source, target and patch CRC32s
+func withFooter(patch, source, target []byte) []byte {
+	footer := make([]byte, 4)
+	binary.LittleEndian.PutUint32(footer, crc32.ChecksumIEEE(source))
+	patch = append(patch, footer...)
+	binary.LittleEndian.PutUint32(footer, crc32.ChecksumIEEE(target))
+	patch = append(patch, footer...)
+	binary.LittleEndian.PutUint32(footer, crc32.ChecksumIEEE(patch))
+	return append(patch, footer...)
+}
+
+func join(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestPatchIPS(t *testing.T) {
+	rom := []byte("0123456789")
+	testCases := []struct {
+		name     string
+		patch    []byte
+		expected string
+	}{
+		{"record", join(ipsMagic, []byte{0, 0, 2, 0, 3}, []byte("abc"), ipsEOF), "01abc56789"},
+		{"run", join(ipsMagic, []byte{0, 0, 1, 0, 0, 0, 4, 'z'}, ipsEOF), "0zzzz56789"},
+		{"grows", join(ipsMagic, []byte{0, 0, 12, 0, 2}, []byte("xy"), ipsEOF), "0123456789\x00\x00xy"},
+		{"truncates", join(ipsMagic, []byte{0, 0, 0, 0, 1}, []byte("a"), ipsEOF, []byte{0, 0, 4}), "a123"},
+	}
+	for _, test := range testCases {
+		actual, err := Patch(rom, test.patch)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", test.name, err)
+		} else if string(actual) != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, actual)
+		}
+	}
+	if string(rom) != "0123456789" {
+		t.Errorf("Expected the ROM to be left alone, got %q", rom)
+	}
+	if _, err := Patch(rom, join(ipsMagic, []byte{0, 0, 2, 0, 3}, []byte("ab"))); errors.Cause(err) != ErrBadPatch {
+		t.Errorf("Expected ErrBadPatch for a cut short patch, got %v", err)
+	}
+}
+
+func TestPatchUPS(t *testing.T) {
+	rom := []byte("0123456789")
+	target := []byte("0A23456789BC")
+	patch := withFooter(join(upsMagic, encodeNumber(10), encodeNumber(12),
+		encodeNumber(1), []byte{'1' ^ 'A', 0},
+		encodeNumber(7), []byte{'B', 'C', 0},
+	), rom, target)
+
+	actual, err := Patch(rom, patch)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if !bytes.Equal(actual, target) {
+		t.Errorf("Expected %q, got %q", target, actual)
+	}
+	if _, err := Patch([]byte("9123456789"), patch); errors.Cause(err) != ErrChecksum {
+		t.Errorf("Expected ErrChecksum for the wrong ROM, got %v", err)
+	}
+	patch[6] ^= 0xFF
+	if _, err := Patch(rom, patch); errors.Cause(err) != ErrChecksum {
+		t.Errorf("Expected ErrChecksum for a damaged patch, got %v", err)
+	}
+}
+
+func TestPatchBPS(t *testing.T) {
+	rom := []byte("abcdefgh")
+	target := []byte("abXYfgabababZ")
+	action := func(kind, length uint64) []byte {
+		return encodeNumber((length-1)<<2 | kind)
+	}
+	patch := withFooter(join(bpsMagic, encodeNumber(8), encodeNumber(13), encodeNumber(3), []byte("abc"),
+		action(bpsSourceRead, 2),
+		action(bpsTargetRead, 2), []byte("XY"),
+		action(bpsSourceCopy, 2), encodeNumber(5<<1),
+		action(bpsSourceCopy, 2), encodeNumber(7<<1|1),
+		// Copies its own output as it writes it
+		action(bpsTargetCopy, 4), encodeNumber(6<<1),
+		action(bpsTargetRead, 1), []byte("Z"),
+	), rom, target)
+
+	actual, err := Patch(rom, patch)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if !bytes.Equal(actual, target) {
+		t.Errorf("Expected %q, got %q", target, actual)
+	}
+	if _, err := Patch([]byte("abcdefg"), patch); errors.Cause(err) != ErrChecksum {
+		t.Errorf("Expected ErrChecksum for a ROM of the wrong size, got %v", err)
+	}
+}
+
+func TestLoadPatched(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goboy-patch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "game.gb"), []byte("0123"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "game.ips"), join(ipsMagic, []byte{0, 0, 0, 0, 1}, []byte("a"), ipsEOF), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "other.ips"), join(ipsMagic, []byte{0, 0, 0, 0, 1}, []byte("b"), ipsEOF), 0644)
+
+	testCases := []struct {
+		patch    string
+		expected string
+	}{
+		{"", "a123"},
+		{filepath.Join(dir, "other.ips"), "b123"},
+	}
+	for _, test := range testCases {
+		actual, err := LoadPatched(filepath.Join(dir, "game.gb"), test.patch)
+		if err != nil {
+			t.Errorf("Patch %q: unexpected error %s", test.patch, err)
+		} else if string(actual) != test.expected {
+			t.Errorf("Patch %q: expected %q, got %q", test.patch, test.expected, actual)
+		}
+	}
+
+	os.Remove(filepath.Join(dir, "game.ips"))
+	if actual, err := LoadPatched(filepath.Join(dir, "game.gb"), ""); err != nil || string(actual) != "0123" {
+		t.Errorf("Expected the ROM unpatched, got %q, %v", actual, err)
+	}
+}
@@ -0,0 +1,176 @@
+// Package romfile reads ROMs from files, archives and standard input
+package romfile
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Stdin is the path that reads the ROM from standard input
+const Stdin = "-"
+
+// MaxSize is the largest ROM a cartridge header can declare. Anything bigger
+// in an archive is refused rather than unpacked.
+const MaxSize = 8 * 1024 * 1024
+
+// MaxArchiveSize is the largest file read, so that a zip can hold several
+// ROMs
+const MaxArchiveSize = 64 * 1024 * 1024
+
+// entrySeparator splits a zip path from the name of the entry to load, as in
+// games.zip:tetris.gb
+const entrySeparator = ".zip:"
+
+var (
+	ErrNoROM    = errors.New("no ROM in archive")
+	ErrTooLarge = errors.New("ROM is too large")
+)
+
+var stdin io.Reader = os.Stdin
+
+// Load reads a ROM. path is relative to the working directory, or "-" for
+// standard input. Zip and gzip files are unpacked. A zip gives its first .gb
+// or .gbc file unless an entry is named after the path, as in
+// games.zip:tetris.gb.
+func Load(path string) ([]byte, error) {
+	path, entry := splitEntry(path)
+
+	var data []byte
+	var err error
+	if path == Stdin {
+		data, err = readAll(stdin, MaxArchiveSize)
+	} else {
+		var file *os.File
+		file, err = os.Open(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't open ROM")
+		}
+		defer file.Close()
+		data, err = readAll(file, MaxArchiveSize)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't read ROM")
+	}
+	return Decode(data, entry)
+}
+
+// Decode unpacks a ROM read from a zip or gzip file, telling them apart by
+// their first bytes. Anything else is returned as it is. entry names the zip
+// entry to use, or is empty for the first .gb or .gbc file.
+func Decode(data []byte, entry string) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		return unzip(data, entry)
+	case bytes.HasPrefix(data, []byte{0x1F, 0x8B}):
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't read gzip")
+		}
+		defer reader.Close()
+		rom, err := readAll(reader, MaxSize)
+		return rom, errors.Wrap(err, "couldn't read gzip")
+	}
+	if entry != "" {
+		return nil, errors.Errorf("can't load %s from a file that isn't a zip", entry)
+	}
+	if len(data) > MaxSize {
+		return nil, ErrTooLarge
+	}
+	return data, nil
+}
+
+func unzip(data []byte, entry string) ([]byte, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't read zip")
+	}
+	for _, file := range archive.File {
+		if file.FileInfo().IsDir() || !matches(file.Name, entry) {
+			continue
+		}
+		reader, err := file.Open()
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't open %s in zip", file.Name)
+		}
+		defer reader.Close()
+		rom, err := readAll(reader, MaxSize)
+		return rom, errors.Wrapf(err, "couldn't read %s in zip", file.Name)
+	}
+	if entry != "" {
+		return nil, errors.Wrapf(ErrNoROM, "no %s", entry)
+	}
+	return nil, errors.Wrap(ErrNoROM, "no .gb or .gbc file")
+}
+
+// matches reports whether a zip entry is the one asked for, either by its
+// full name or just the file name. Without a name any ROM will do.
+func matches(name, entry string) bool {
+	if entry != "" {
+		return name == entry || filepath.Base(name) == entry
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".gb", ".gbc":
+		return true
+	}
+	return false
+}
+
+// splitEntry splits games.zip:tetris.gb into the zip's path and the entry
+func splitEntry(path string) (string, string) {
+	if i := strings.LastIndex(strings.ToLower(path), entrySeparator); i >= 0 {
+		split := i + len(entrySeparator)
+		return path[:split-1], path[split:]
+	}
+	return path, ""
+}
+
+func readAll(r io.Reader, limit int64) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, ErrTooLarge
+	}
+	return data, nil
+}
+
+// SavePath returns where to keep a ROM's battery-backed RAM: next to it, or
+// next to the archive it came from, with the extension .sav. A named zip entry
+// adds its name, as in games.tetris.sav, so each ROM in a zip has its own.
+// ROMs from standard input aren't saved, so it returns "".
+func SavePath(path string) string {
+	return alongside(path, ".sav")
+}
+
+// CheatsPath returns where to keep a ROM's cheats, like SavePath but with
+// the extension .cht
+func CheatsPath(path string) string {
+	return alongside(path, ".cht")
+}
+
+// alongside returns the path of a file next to a ROM with its name and
+// another extension, or "" for standard input
+func alongside(path, extension string) string {
+	path, entry := splitEntry(path)
+	if path == Stdin {
+		return ""
+	}
+	if strings.EqualFold(filepath.Ext(path), ".gz") {
+		path = strings.TrimSuffix(path, filepath.Ext(path))
+	}
+	path = strings.TrimSuffix(path, filepath.Ext(path))
+	if entry != "" {
+		entry = filepath.Base(entry)
+		path += "." + strings.TrimSuffix(entry, filepath.Ext(entry))
+	}
+	return path + extension
+}
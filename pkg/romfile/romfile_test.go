@@ -0,0 +1,149 @@
+package romfile
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func createZip(t *testing.T, files ...string) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(name))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func createGzip(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// createLargeZip returns a zip bigger than MaxSize whose first ROM is too
+// large to load and whose second is small
+func createLargeZip(t *testing.T) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range []string{"big.gb", "small.gb"} {
+		f, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name == "big.gb" {
+			f.Write(make([]byte, MaxSize+1))
+		} else {
+			f.Write([]byte(name))
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goboy-romfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name string, data []byte) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("plain.gb", []byte("plain"))
+	write("game.gb.gz", createGzip(t, []byte("gzipped")))
+	write("games.zip", createZip(t, "readme.txt", "roms/", "roms/first.GB", "roms/second.gbc"))
+	write("empty.zip", createZip(t, "readme.txt"))
+	write("large.zip", createLargeZip(t))
+
+	testCases := []struct {
+		path     string
+		expected string
+	}{
+		{"plain.gb", "plain"},
+		{"game.gb.gz", "gzipped"},
+		{"games.zip", "roms/first.GB"},
+		{"games.zip:roms/second.gbc", "roms/second.gbc"},
+		{"games.zip:second.gbc", "roms/second.gbc"},
+		{"games.zip:readme.txt", "readme.txt"},
+		{"large.zip:small.gb", "small.gb"},
+	}
+	for _, test := range testCases {
+		actual, err := Load(filepath.Join(dir, test.path))
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", test.path, err)
+		} else if string(actual) != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.path, test.expected, actual)
+		}
+	}
+
+	for _, path := range []string{"empty.zip", "games.zip:missing.gb"} {
+		if _, err := Load(filepath.Join(dir, path)); errors.Cause(err) != ErrNoROM {
+			t.Errorf("%s: expected ErrNoROM, got %v", path, err)
+		}
+	}
+	if _, err := Load(filepath.Join(dir, "large.zip")); errors.Cause(err) != ErrTooLarge {
+		t.Errorf("Expected ErrTooLarge, got %v", err)
+	}
+	if _, err := Load(filepath.Join(dir, "missing.gb")); err == nil {
+		t.Errorf("Expected an error for a missing file")
+	}
+}
+
+func TestLoadStdin(t *testing.T) {
+	defer func(r io.Reader) { stdin = r }(stdin)
+	stdin = bytes.NewReader(createZip(t, "piped.gb"))
+	actual, err := Load(Stdin)
+	if err != nil || string(actual) != "piped.gb" {
+		t.Errorf("Expected %q from stdin, got %q, %v", "piped.gb", actual, err)
+	}
+
+	stdin = bytes.NewReader(createGzip(t, make([]byte, MaxSize+1)))
+	if _, err := Load(Stdin); errors.Cause(err) != ErrTooLarge {
+		t.Errorf("Expected ErrTooLarge, got %v", err)
+	}
+}
+
+func TestSavePath(t *testing.T) {
+	testCases := []struct {
+		path     string
+		expected string
+	}{
+		{"roms/tetris.gb", "roms/tetris.sav"},
+		{"/roms/tetris.gb.gz", "/roms/tetris.sav"},
+		{"games.zip", "games.sav"},
+		{"games.zip:tetris.gb", "games.tetris.sav"},
+		{"games.zip:roms/Mario.GB", "games.Mario.sav"},
+		{Stdin, ""},
+	}
+	for _, test := range testCases {
+		if actual := SavePath(test.path); actual != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.path, test.expected, actual)
+		}
+	}
+	if actual := CheatsPath("games.zip:tetris.gb"); actual != "games.tetris.cht" {
+		t.Errorf("Expected cheats in %q, got %q", "games.tetris.cht", actual)
+	}
+}